@@ -51,3 +51,11 @@ func getDiskInfo() ([]z.Disk, error) {
 
 	return out, nil
 }
+
+func copyFile(src, dest string) error {
+	d, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dest, d, 0644)
+}
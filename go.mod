@@ -2,4 +2,7 @@ module github.com/twitchylinux/twlinst
 
 go 1.15
 
-require github.com/gotk3/gotk3 v0.6.1
+require (
+	github.com/godbus/dbus/v5 v5.1.0
+	github.com/gotk3/gotk3 v0.6.1
+)
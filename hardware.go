@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/twitchylinux/twlinst/z"
+)
+
+// hardwareProfiles describes the available nixos-hardware profiles.
+type hardwareProfiles struct {
+	// Paths maps the name of each profile to its import path.
+	Paths map[string]string
+	// Rules optionally describe the machines each profile applies to.
+	Rules map[string][]z.HardwareMatch
+}
+
+// loadHardwareProfiles reads the nixos-hardware profiles from a JSON file,
+// which maps profile names to import paths. The reserved "_match" key may
+// map profile names to a list of rules matching the machines they apply to.
+func loadHardwareProfiles(path string) (*hardwareProfiles, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(f).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	out := hardwareProfiles{Paths: make(map[string]string, len(raw))}
+	for k, v := range raw {
+		switch {
+		case k == "_match":
+			if err := json.Unmarshal(v, &out.Rules); err != nil {
+				return nil, fmt.Errorf("decoding match rules: %v", err)
+			}
+		case strings.HasPrefix(k, "_"):
+			continue
+		default:
+			var p string
+			if err := json.Unmarshal(v, &p); err != nil {
+				return nil, fmt.Errorf("decoding profile %q: %v", k, err)
+			}
+			out.Paths[k] = p
+		}
+	}
+	return &out, nil
+}
+
+// Names returns the names of all profiles, sorted.
+func (p *hardwareProfiles) Names() []string {
+	out := make([]string, 0, len(p.Paths))
+	for k := range p.Paths {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Detect returns the profiles which match the running machine, best
+// match first.
+func (p *hardwareProfiles) Detect() ([]z.HardwareCandidate, error) {
+	info, err := z.ReadHardwareInfo()
+	if err != nil {
+		return nil, err
+	}
+	return z.MatchHardwareProfiles(info, p.Names(), p.Rules), nil
+}
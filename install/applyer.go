@@ -11,122 +11,537 @@ import (
 	"strings"
 )
 
+// markerSyntax matches markers written in comments of one syntax.
+type markerSyntax struct {
+	comment        []byte
+	fileMarkerExp  *regexp.Regexp
+	lineMarkerExp  *regexp.Regexp
+	startMarkerExp *regexp.Regexp
+	endMarkerExp   *regexp.Regexp
+}
+
+func newMarkerSyntax(comment string) *markerSyntax {
+	c := regexp.QuoteMeta(comment)
+	return &markerSyntax{
+		comment:        []byte(comment),
+		fileMarkerExp:  regexp.MustCompile("(?m)\\s*" + c + " File-marker: (.+)$"),
+		lineMarkerExp:  regexp.MustCompile("(?m)\\s*" + c + " Line-marker: (.+)$"),
+		startMarkerExp: regexp.MustCompile("\\s*" + c + " Start-marker: (.+)$"),
+		endMarkerExp:   regexp.MustCompile("\\s*" + c + " End-marker: (.+)$"),
+	}
+}
+
 var (
-	fileMarkerExp  = regexp.MustCompile("(?m)\\s*# File-marker: (.+)$")
-	lineMarkerExp  = regexp.MustCompile("(?m)\\s*# Line-marker: (.+)$")
-	startMarkerExp = regexp.MustCompile("\\s*# Start-marker: (.+)$")
-	endMarkerExp   = regexp.MustCompile("\\s*# End-marker: (.+)$")
+	hashComments  = newMarkerSyntax("#")
+	slashComments = newMarkerSyntax("//")
+	dashComments  = newMarkerSyntax("--")
+
+	// commentSyntaxes maps file extensions to the comment syntax markers
+	// are written in. Files with other extensions use hashComments if
+	// they are included.
+	commentSyntaxes = map[string]*markerSyntax{
+		".nix": hashComments, ".sh": hashComments, ".bash": hashComments,
+		".py": hashComments, ".conf": hashComments, ".cfg": hashComments,
+		".ini": hashComments, ".toml": hashComments, ".yaml": hashComments,
+		".yml": hashComments,
+
+		".js": slashComments, ".ts": slashComments, ".c": slashComments,
+		".h": slashComments, ".cpp": slashComments, ".go": slashComments,
+		".rs": slashComments, ".json5": slashComments,
+
+		".lua": dashComments, ".sql": dashComments, ".hs": dashComments,
+	}
 )
 
+// storeDir is the Nix store, whose contents are immutable.
+const storeDir = "/nix/store"
+
+// AppliedChange describes a file modified or deleted by the Applyer.
+type AppliedChange struct {
+	Path    string
+	Deleted bool
+}
+
 // Applyer mutates the nix installer config to setup an installation.
 type Applyer struct {
 	TwlBasePath string
 	Run         *Run
+
+	// Include and Exclude are globs selecting the files to process, matched
+	// against the path relative to TwlBasePath and against the file name.
+	// By default every file with an extension in commentSyntaxes is
+	// included. Excluded directories are skipped entirely.
+	Include, Exclude []string
+
+	// Changes lists the files changed by the Applyer, in order.
+	Changes []AppliedChange
 }
 
-func (a *Applyer) applyFile(srcPath string, size int64) error {
-	if !strings.HasSuffix(srcPath, ".nix") {
-		return nil
+// syntax returns the comment syntax of the file.
+func syntax(srcPath string) *markerSyntax {
+	if s, ok := commentSyntaxes[filepath.Ext(srcPath)]; ok {
+		return s
+	}
+	return hashComments
+}
+
+// markerKind is the kind of marker on a line.
+type markerKind int
+
+const (
+	noMarker markerKind = iota
+	fileMarker
+	lineMarker
+	startMarker
+	endMarker
+)
+
+// markerLine is a line of a file, and the marker on it if any.
+type markerLine struct {
+	num    int
+	text   []byte
+	kind   markerKind
+	marker string
+	spec   markerSpec
+	// content is the text of a line before its line marker.
+	content []byte
+	// section is the start marker of the section the line is within or
+	// delimits, if any.
+	section *markerLine
+}
+
+// scanMarkers splits a file into lines and parses the markers on them.
+// Problems with the markers are returned as diagnostics, so they can all
+// be reported. Sections with unknown markers are left as they are when
+// applying, so those diagnostics are tolerated by the Applyer.
+func scanMarkers(srcPath string, contents []byte) ([]*markerLine, []Diagnostic, error) {
+	var (
+		lines []*markerLine
+		diags []Diagnostic
+		// open are the start markers of the sections being scanned, which
+		// are nested only in malformed files.
+		open    []*markerLine
+		file    *markerLine
+		syn     = syntax(srcPath)
+		scanner = bufio.NewScanner(bytes.NewReader(contents))
+	)
+	report := func(l *markerLine, format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{Path: srcPath, Line: l.num, Msg: fmt.Sprintf(format, args...)})
 	}
+	parse := func(l *markerLine) {
+		spec, err := parseMarker(l.marker)
+		if err != nil {
+			report(l, "%v", err)
+			return
+		}
+		l.spec = spec
+
+		switch {
+		case l.kind == fileMarker && spec.action != actionDrop && spec.action != actionConditional:
+			report(l, "unknown file marker: %q", l.marker)
+		case l.kind == lineMarker && spec.action == actionUnknown:
+			report(l, "unknown line marker: %q", l.marker)
+		case l.kind == startMarker && spec.action == actionUnknown:
+			report(l, "unknown section marker: %q", l.marker)
+			diags[len(diags)-1].tolerated = true
+		case spec.action != actionSubstitute:
+		case valueSyntaxes[filepath.Ext(srcPath)] == nil:
+			report(l, "substitutions are not supported in %s files", filepath.Ext(srcPath))
+		case l.kind == lineMarker:
+			if _, _, ok := valueSpan(l.content); !ok {
+				report(l, "marker %q: no value to substitute", l.marker)
+			}
+		}
+	}
+
+	for num := 1; scanner.Scan(); num++ {
+		l := &markerLine{num: num, text: append([]byte{}, scanner.Bytes()...)}
+		if len(open) > 0 {
+			l.section = open[len(open)-1]
+		}
+		lines = append(lines, l)
+
+		if m := syn.startMarkerExp.FindSubmatch(l.text); len(m) > 0 {
+			l.kind, l.marker, l.section = startMarker, string(m[1]), l
+			if len(open) > 0 {
+				outer := open[len(open)-1]
+				report(l, "section %q is nested in section %q from line %d", l.marker, outer.marker, outer.num)
+			}
+			parse(l)
+			open = append(open, l)
+		} else if m := syn.endMarkerExp.FindSubmatch(l.text); len(m) > 0 {
+			l.kind, l.marker = endMarker, string(m[1])
+			if len(open) == 0 {
+				report(l, "end marker %q has no start marker", l.marker)
+				continue
+			}
+			if start := open[len(open)-1]; start.marker != l.marker {
+				report(l, "end marker %q does not match start marker %q on line %d", l.marker, start.marker, start.num)
+			}
+			open = open[:len(open)-1]
+		} else if m := syn.fileMarkerExp.FindSubmatch(l.text); len(m) > 0 {
+			// Only the first file marker is applied, wherever it is.
+			l.kind, l.marker = fileMarker, string(m[1])
+			if file != nil {
+				report(l, "more than one file marker, the first is on line %d", file.num)
+				continue
+			}
+			file = l
+			parse(l)
+		} else if l.section != nil {
+			// Line markers within sections are content of the section.
+		} else if m := syn.lineMarkerExp.FindSubmatchIndex(l.text); m != nil {
+			l.kind, l.marker, l.content = lineMarker, string(l.text[m[2]:m[3]]), l.text[:m[0]]
+			parse(l)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("scanning: %v", err)
+	}
+
+	for _, s := range open {
+		report(s, "section %q has no end marker", s.marker)
+	}
+	return lines, diags, nil
+}
+
+func (a *Applyer) applyFile(srcPath string, size int64) error {
 	contents, err := ioutil.ReadFile(srcPath)
 	if err != nil {
 		return err
 	}
 
-	if fileMarker := fileMarkerExp.FindSubmatch(contents); len(fileMarker) > 0 {
-		return a.handleFileMarker(srcPath, string(fileMarker[1]))
+	lines, diags, err := scanMarkers(srcPath, contents)
+	if err != nil {
+		return err
+	}
+	for _, d := range diags {
+		if !d.tolerated {
+			return fmt.Errorf("line %d: %s", d.Line, d.Msg)
+		}
+	}
+
+	for _, l := range lines {
+		if l.kind == fileMarker {
+			removed, err := a.handleFileMarker(srcPath, l)
+			if err != nil || removed {
+				return err
+			}
+			break
+		}
+	}
+	return a.handleInlineMarkers(srcPath, lines, size)
+}
+
+// uncomment removes the comment syntax from the start of the line,
+// keeping its indentation.
+func (s *markerSyntax) uncomment(line []byte) []byte {
+	indent := indentOf(line)
+	rest := line[len(indent):]
+	if !bytes.HasPrefix(rest, s.comment) {
+		return line
+	}
+	rest = bytes.TrimPrefix(rest[len(s.comment):], []byte(" "))
+	return append(append([]byte{}, indent...), rest...)
+}
+
+// indentOf returns the leading whitespace of the line.
+func indentOf(line []byte) []byte {
+	return line[:len(line)-len(bytes.TrimLeft(line, " \t"))]
+}
+
+// Markers which are not conditions.
+const (
+	trimMarker       = "Trim on install"
+	uncommentMarker  = "Uncomment on install"
+	substitutePrefix = "Substitute "
+)
+
+// markerAction is what the Applyer does with marked content.
+type markerAction int
+
+const (
+	// actionUnknown leaves sections with unknown markers as they are,
+	// including the markers.
+	actionUnknown markerAction = iota
+	actionKeep
+	actionDrop
+	actionUncomment
+	actionSubstitute
+	// actionConditional is actionKeep or actionDrop, depending on the
+	// install settings.
+	actionConditional
+)
+
+// markerSpec is what a marker does, before it is evaluated against the
+// install settings.
+type markerSpec struct {
+	action markerAction
+	field  string    // the field of a Substitute marker
+	cond   condition // the condition of an If or Unless marker
+	negate bool
+}
+
+// parseMarker returns what the marker does. Markers which are not
+// understood have actionUnknown.
+func parseMarker(marker string) (markerSpec, error) {
+	switch {
+	case marker == trimMarker:
+		return markerSpec{action: actionDrop}, nil
+	case marker == uncommentMarker:
+		return markerSpec{action: actionUncomment}, nil
+	case strings.HasPrefix(marker, substitutePrefix):
+		field := marker[len(substitutePrefix):]
+		if substitutionFields[field] == nil {
+			return markerSpec{}, fmt.Errorf("unknown substitution %q", field)
+		}
+		return markerSpec{action: actionSubstitute, field: field}, nil
+	case strings.HasPrefix(marker, "If "), strings.HasPrefix(marker, "Unless "):
+		negate := strings.HasPrefix(marker, "Unless ")
+		c, err := parseCondition(marker[strings.IndexByte(marker, ' ')+1:])
+		if err != nil {
+			return markerSpec{}, fmt.Errorf("marker %q: %v", marker, err)
+		}
+		return markerSpec{action: actionConditional, cond: c, negate: negate}, nil
+	}
+	return markerSpec{action: actionUnknown}, nil
+}
+
+// action returns what to do with the content the marker on the line
+// applies to.
+func (a *Applyer) action(l *markerLine) (markerAction, error) {
+	if l.spec.action != actionConditional {
+		return l.spec.action, nil
 	}
+	if a.Run == nil {
+		return actionUnknown, fmt.Errorf("marker %q: no install settings", l.marker)
+	}
+	if l.spec.cond.eval(&a.Run.config) != l.spec.negate {
+		return actionKeep, nil
+	}
+	return actionDrop, nil
+}
+
+// substitute returns the value replacing content marked with a Substitute
+// marker in the file at srcPath.
+func (a *Applyer) substitute(marker, srcPath string, indent []byte) ([]byte, error) {
+	if a.Run == nil {
+		return nil, fmt.Errorf("marker %q: no install settings", marker)
+	}
+	v, err := renderSubstitution(&a.Run.config, srcPath, marker[len(substitutePrefix):], string(indent))
+	return []byte(v), err
+}
 
-	return a.handleInlineMarkers(srcPath, contents, size)
+// valueSpan returns the bounds of the value assigned on a line: the text
+// after the first = (or : if there is none), without a trailing ; or ,.
+func valueSpan(content []byte) (start, end int, ok bool) {
+	sep := bytes.IndexByte(content, '=')
+	if sep < 0 {
+		sep = bytes.IndexByte(content, ':')
+	}
+	if sep < 0 {
+		return 0, 0, false
+	}
+	start = sep + 1
+	for start < len(content) && (content[start] == ' ' || content[start] == '\t') {
+		start++
+	}
+	end = len(bytes.TrimRight(content, " \t"))
+	if end > start && (content[end-1] == ';' || content[end-1] == ',') {
+		end = len(bytes.TrimRight(content[:end-1], " \t"))
+	}
+	return start, end, end > start
 }
 
-func (a *Applyer) handleInlineMarkers(srcPath string, contents []byte, size int64) error {
+func (a *Applyer) handleInlineMarkers(srcPath string, lines []*markerLine, size int64) error {
 	var (
 		out        bytes.Buffer
 		hasChanges bool
+		syn        = syntax(srcPath)
+		// section is the action for the lines of the current section.
+		section markerAction
 	)
 	out.Grow(int(size))
 
-	var (
-		scanner   = bufio.NewScanner(bytes.NewReader(contents))
-		lastStart = ""
-	)
-	for scanner.Scan() {
-		switch {
-		case lastStart == "": // Not within any section
-			if m := startMarkerExp.FindSubmatch(scanner.Bytes()); len(m) > 0 {
-				lastStart = string(m[1])
+	for _, l := range lines {
+		line := l.text
 
-				if string(m[1]) == "Trim on install" {
-					continue
-				}
-			} else {
-				// Check for any line markers
-				if m := lineMarkerExp.FindSubmatch(scanner.Bytes()); len(m) > 0 {
-					switch string(m[1]) {
-					case "Trim on install":
-						hasChanges = true
-						continue
-					default:
-						return fmt.Errorf("unknown line marker: %q", string(m[1]))
-					}
+		switch {
+		case l.kind == startMarker:
+			act, err := a.action(l)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", l.num, err)
+			}
+			section = act
+			if act == actionUnknown {
+				break
+			}
+			hasChanges = true
+			if act == actionSubstitute {
+				indent := indentOf(line)
+				value, err := a.substitute(l.marker, srcPath, indent)
+				if err != nil {
+					return fmt.Errorf("line %d: %v", l.num, err)
 				}
+				out.Write(indent)
+				out.Write(value)
+				out.WriteRune('\n')
 			}
+			continue
 
-		case lastStart != "": // within a section
-			if m := endMarkerExp.FindSubmatch(scanner.Bytes()); len(m) > 0 {
-				if string(m[1]) != lastStart {
-					return fmt.Errorf("unmatched start/end: %s != %s", string(m[1]), lastStart)
-				}
-				lastStart = ""
+		case l.kind == endMarker:
+			if section != actionUnknown {
 				hasChanges = true
+				continue
+			}
 
-				if string(m[1]) == "Trim on install" {
-					continue
-				}
-			} else {
-				// Handle logic for specific section
-				switch lastStart {
-				case "Trim on install":
-					continue
+		case l.section != nil: // within a section
+			switch section {
+			case actionDrop, actionSubstitute:
+				continue
+			case actionUncomment:
+				line = syn.uncomment(line)
+			}
+
+		case l.kind == lineMarker:
+			act, err := a.action(l)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", l.num, err)
+			}
+			hasChanges = true
+
+			// The line is kept without the marker.
+			content := l.content
+			switch act {
+			case actionDrop:
+				continue
+			case actionUncomment:
+				content = syn.uncomment(content)
+			case actionSubstitute:
+				// Only the value assigned on the line is replaced.
+				start, end, _ := valueSpan(content)
+				value, err := a.substitute(l.marker, srcPath, indentOf(line))
+				if err != nil {
+					return fmt.Errorf("line %d: %v", l.num, err)
 				}
+				content = append(append(append([]byte{}, content[:start]...), value...), content[end:]...)
+			}
+			if len(bytes.TrimSpace(content)) == 0 {
+				continue
 			}
+			line = content
+
+		case l.kind == fileMarker:
+			// The marker of a file which was kept.
+			hasChanges = true
+			continue
 		}
 
-		out.Write(scanner.Bytes())
+		out.Write(line)
 		out.WriteRune('\n')
 	}
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("scanning: %v", err)
-	}
 
 	if hasChanges {
-		return ioutil.WriteFile(srcPath, out.Bytes(), 0644)
+		if err := ioutil.WriteFile(srcPath, out.Bytes(), 0644); err != nil {
+			return err
+		}
+		a.Changes = append(a.Changes, AppliedChange{Path: srcPath})
 	}
 	return nil
 }
 
-func (a *Applyer) handleFileMarker(srcPath, marker string) error {
-	switch marker {
-	case "Trim on install":
-		return os.Remove(srcPath)
-	default:
-		return fmt.Errorf("unknown file marker: %q", marker)
+// handleFileMarker removes the file if the marker on the line says to,
+// returning whether it was removed.
+func (a *Applyer) handleFileMarker(srcPath string, l *markerLine) (bool, error) {
+	act, err := a.action(l)
+	if err != nil || act != actionDrop {
+		return false, err
 	}
+
+	if err := os.Remove(srcPath); err != nil {
+		return false, err
+	}
+	a.Changes = append(a.Changes, AppliedChange{Path: srcPath, Deleted: true})
+	return true, nil
+}
+
+// matchAny returns true if any of the globs match the relative path or its
+// final element.
+func matchAny(globs []string, rel string) bool {
+	for _, g := range globs {
+		if ok, _ := filepath.Match(g, rel); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(g, filepath.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// included returns true if the file at the relative path should be
+// processed.
+func (a *Applyer) included(rel string) bool {
+	if len(a.Include) == 0 {
+		_, ok := commentSyntaxes[filepath.Ext(rel)]
+		return ok
+	}
+	return matchAny(a.Include, rel)
+}
+
+// walk calls fn for each included file under TwlBasePath.
+func (a *Applyer) walk(fn func(srcPath string, size int64) error) error {
+	for _, g := range append(append([]string{}, a.Include...), a.Exclude...) {
+		if _, err := filepath.Match(g, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", g, err)
+		}
+	}
+
+	root, err := filepath.EvalSymlinks(a.TwlBasePath)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if matchAny(a.Exclude, rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Walk does not follow symlinks, which may point outside the tree
+		// or into the store.
+		if !info.Mode().IsRegular() || !a.included(rel) {
+			return nil
+		}
+		return fn(filepath.Join(a.TwlBasePath, rel), info.Size())
+	})
 }
 
 func (a *Applyer) Exec() error {
-	entries, err := ioutil.ReadDir(a.TwlBasePath)
+	// Files in the store cannot be changed, and may be shared with other
+	// systems.
+	root, err := filepath.EvalSymlinks(a.TwlBasePath)
 	if err != nil {
 		return err
 	}
+	if root == storeDir || strings.HasPrefix(root, storeDir+"/") {
+		return fmt.Errorf("%s is in the Nix store", a.TwlBasePath)
+	}
 
-	for _, f := range entries {
-		srcPath := filepath.Join(a.TwlBasePath, f.Name())
-		if err := a.applyFile(srcPath, f.Size()); err != nil {
+	return a.walk(func(srcPath string, size int64) error {
+		if err := a.applyFile(srcPath, size); err != nil {
 			return fmt.Errorf("applying %s: %v", srcPath, err)
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
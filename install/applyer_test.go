@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"reflect"
 	"testing"
 )
 
@@ -98,3 +99,338 @@ func TestInlineMarkers(t *testing.T) {
 	})
 
 }
+
+func TestApplyerChanges(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(path.Join(dir, "a-trimmed.nix"), []byte("{\n  x = 1; # Line-marker: Trim on install\n}\n"), 0644)
+	ioutil.WriteFile(path.Join(dir, "b-removed.nix"), []byte("# File-marker: Trim on install\n{}\n"), 0644)
+	ioutil.WriteFile(path.Join(dir, "c-unchanged.nix"), []byte("{}\n"), 0644)
+	// Sections with unknown markers are left as they are.
+	ioutil.WriteFile(path.Join(dir, "d-unknown.nix"), []byte("# Start-marker: Keep for now\n{}\n# End-marker: Keep for now\n"), 0644)
+
+	a := &Applyer{TwlBasePath: dir}
+	if err := a.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	want := []AppliedChange{
+		{Path: path.Join(dir, "a-trimmed.nix")},
+		{Path: path.Join(dir, "b-removed.nix"), Deleted: true},
+	}
+	if !reflect.DeepEqual(a.Changes, want) {
+		t.Errorf("Changes = %+v, want %+v", a.Changes, want)
+	}
+}
+
+func TestApplyerWalk(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	outside, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(outside)
+
+	files := map[string]string{
+		"modules/desktop/default.nix": "{\n  x = 1; # Line-marker: Trim on install\n}\n",
+		"scripts/setup.sh":            "echo live\n# Start-marker: Trim on install\nrm -rf /live\n# End-marker: Trim on install\n",
+		"config/init.lua":             "a = 1 -- Line-marker: Trim on install\nb = 2\n",
+		"config/app.js":               "// File-marker: Trim on install\nlet x = 1;\n",
+		"config/notes.txt":            "x # Line-marker: Trim on install\n",
+		"vendor/skip.nix":             "y # Line-marker: Trim on install\n",
+	}
+	for name, contents := range files {
+		p := path.Join(dir, name)
+		os.MkdirAll(path.Dir(p), 0755)
+		ioutil.WriteFile(p, []byte(contents), 0644)
+	}
+	linked := path.Join(outside, "linked.nix")
+	ioutil.WriteFile(linked, []byte("z # Line-marker: Trim on install\n"), 0644)
+	os.Symlink(linked, path.Join(dir, "modules", "linked.nix"))
+
+	a := &Applyer{TwlBasePath: dir, Exclude: []string{"vendor"}}
+	if err := a.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"modules/desktop/default.nix": "{\n}\n",
+		"scripts/setup.sh":            "echo live\n",
+		"config/init.lua":             "b = 2\n",
+		"config/notes.txt":            files["config/notes.txt"],
+		"vendor/skip.nix":             files["vendor/skip.nix"],
+	}
+	for name, contents := range want {
+		if b, _ := ioutil.ReadFile(path.Join(dir, name)); string(b) != contents {
+			t.Errorf("%s = %q, want %q", name, b, contents)
+		}
+	}
+	if _, err := os.Stat(path.Join(dir, "config/app.js")); !os.IsNotExist(err) {
+		t.Errorf("config/app.js: wanted not found, got %v", err)
+	}
+	if b, _ := ioutil.ReadFile(linked); string(b) != "z # Line-marker: Trim on install\n" {
+		t.Errorf("symlink target was modified: %q", b)
+	}
+
+	t.Run("include", func(t *testing.T) {
+		a := &Applyer{TwlBasePath: dir, Include: []string{"*.txt"}}
+		if err := a.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := ioutil.ReadFile(path.Join(dir, "config/notes.txt")); string(b) != "" {
+			t.Errorf("config/notes.txt = %q, want it trimmed", b)
+		}
+		if b, _ := ioutil.ReadFile(path.Join(dir, "vendor/skip.nix")); string(b) != files["vendor/skip.nix"] {
+			t.Errorf("vendor/skip.nix = %q, want it unchanged", b)
+		}
+	})
+
+	t.Run("bad pattern", func(t *testing.T) {
+		if err := (&Applyer{TwlBasePath: dir, Exclude: []string{"["}}).Exec(); err == nil {
+			t.Error("Exec() succeeded with an invalid pattern")
+		}
+	})
+}
+
+func TestConditionalMarkers(t *testing.T) {
+	run := &Run{config: Settings{
+		Autologin:            true,
+		NixosHardwareImports: HardwareImports{"lenovo/thinkpad/x230"},
+	}}
+
+	tcs := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "if kept",
+			in:   "a\n# Start-marker: If autologin\nb\n# End-marker: If autologin\nc\n",
+			want: "a\nb\nc\n",
+		},
+		{
+			name: "if removed",
+			in:   "a\n# Start-marker: If ssh\nb\n# End-marker: If ssh\nc\n",
+			want: "a\nc\n",
+		},
+		{
+			name: "unless",
+			in:   "a\n  # Start-marker: Unless hardware=lenovo-*\n  b\n  # End-marker: Unless hardware=lenovo-*\n",
+			want: "a\n",
+		},
+		{
+			name: "line kept",
+			in:   "a\n  b = 1; # Line-marker: If encrypted\n",
+			want: "a\n  b = 1;\n",
+		},
+		{
+			name: "line removed",
+			in:   "a\n  b = 1; # Line-marker: Unless encrypted\n",
+			want: "a\n",
+		},
+		{
+			name:    "unknown field",
+			in:      "a # Line-marker: If colour=blue\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated",
+			in:      "# Start-marker: If autologin\na\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, _ := ioutil.TempDir("", "")
+			defer os.RemoveAll(dir)
+			p := path.Join(dir, "cond.nix")
+			ioutil.WriteFile(p, []byte(tc.in), 0644)
+
+			err := (&Applyer{Run: run}).applyFile(p, 0)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("applyFile() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if b, _ := ioutil.ReadFile(p); string(b) != tc.want {
+				t.Errorf("content = %q, want %q", b, tc.want)
+			}
+		})
+	}
+
+	t.Run("file", func(t *testing.T) {
+		dir, _ := ioutil.TempDir("", "")
+		defer os.RemoveAll(dir)
+		kept, removed := path.Join(dir, "kept.nix"), path.Join(dir, "removed.nix")
+		ioutil.WriteFile(kept, []byte("# File-marker: If hardware=lenovo/thinkpad/*\n{}\n"), 0644)
+		ioutil.WriteFile(removed, []byte("# File-marker: Unless autologin\n{}\n"), 0644)
+
+		if err := (&Applyer{TwlBasePath: dir, Run: run}).Exec(); err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := ioutil.ReadFile(kept); string(b) != "{}\n" {
+			t.Errorf("kept.nix = %q, want %q", b, "{}\n")
+		}
+		if _, err := os.Stat(removed); !os.IsNotExist(err) {
+			t.Errorf("removed.nix: wanted not found, got %v", err)
+		}
+	})
+}
+
+func TestUncommentMarkers(t *testing.T) {
+	tcs := []struct {
+		name string
+		file string
+		in   string
+		want string
+	}{
+		{
+			name: "section",
+			file: "uncomment.nix",
+			in:   "{\n  # Start-marker: Uncomment on install\n  # boot.loader.timeout = 0;\n  #services.fstrim.enable = true;\n  x = 1;\n  # End-marker: Uncomment on install\n}\n",
+			want: "{\n  boot.loader.timeout = 0;\n  services.fstrim.enable = true;\n  x = 1;\n}\n",
+		},
+		{
+			name: "line",
+			file: "uncomment.nix",
+			in:   "{\n  # y = 2; # Line-marker: Uncomment on install\n}\n",
+			want: "{\n  y = 2;\n}\n",
+		},
+		{
+			name: "nested comment",
+			file: "uncomment.nix",
+			in:   "# Start-marker: Uncomment on install\n# # A comment in the live config.\n# End-marker: Uncomment on install\n",
+			want: "# A comment in the live config.\n",
+		},
+		{
+			name: "other syntax",
+			file: "uncomment.lua",
+			in:   "-- Start-marker: Uncomment on install\n-- installed = true\n-- End-marker: Uncomment on install\n",
+			want: "installed = true\n",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, _ := ioutil.TempDir("", "")
+			defer os.RemoveAll(dir)
+			p := path.Join(dir, tc.file)
+			ioutil.WriteFile(p, []byte(tc.in), 0644)
+
+			if err := (&Applyer{}).applyFile(p, 0); err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := ioutil.ReadFile(p); string(b) != tc.want {
+				t.Errorf("content = %q, want %q", b, tc.want)
+			}
+		})
+	}
+}
+
+func TestSubstituteMarkers(t *testing.T) {
+	run := &Run{config: Settings{
+		Username:       "alice",
+		Hostname:       "${builtins.exec \"rm\"}",
+		AuthorizedKeys: AuthorizedKeys{Keys: []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIG4rT3vTt99Ox5kndS4HmgTrKBT8SKzhK4rhGkEVGlCI alice@laptop"}},
+		SSH:            SSHSettings{Enable: true},
+		Users:          []User{{Name: "bob"}},
+	}}
+
+	tcs := []struct {
+		name    string
+		file    string // subst.nix if empty
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "hostname escaped",
+			in:   "{\n  networking.hostName =\n    # Start-marker: Substitute hostname\n    \"twl-live\"\n    # End-marker: Substitute hostname\n  ;\n}\n",
+			want: "{\n  networking.hostName =\n    \"\\${builtins.exec \\\"rm\\\"}\"\n  ;\n}\n",
+		},
+		{
+			name: "username line",
+			in:   "  user = \"nixos\"; # Line-marker: Substitute username\n",
+			want: "  user = \"alice\";\n",
+		},
+		{
+			name:    "line without value",
+			in:      "  \"nixos\" # Line-marker: Substitute username\n",
+			wantErr: true,
+		},
+		{
+			name: "ssh keys",
+			in:   "keys =\n  # Start-marker: Substitute ssh_keys\n  [ ]\n  # End-marker: Substitute ssh_keys\n;\n",
+			// Long lists are split over lines, indented from the marker.
+			want: "keys =\n  [\n    \"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIG4rT3vTt99Ox5kndS4HmgTrKBT8SKzhK4rhGkEVGlCI alice@laptop\"\n  ]\n;\n",
+		},
+		{
+			name: "users",
+			in:   "# Start-marker: Substitute users\n[ \"nixos\" ]\n# End-marker: Substitute users\n",
+			want: "[ \"alice\" \"bob\" ]\n",
+		},
+		{
+			name: "bool",
+			in:   "# Start-marker: Substitute ssh\nfalse\n# End-marker: Substitute ssh\n",
+			want: "true\n",
+		},
+		{
+			name: "tab indented list",
+			in:   "\tkeys = [ ]; # Line-marker: Substitute ssh_keys\n",
+			want: "\tkeys = [\n\t\t\"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIG4rT3vTt99Ox5kndS4HmgTrKBT8SKzhK4rhGkEVGlCI alice@laptop\"\n\t];\n",
+		},
+		{
+			name: "shell",
+			file: "subst.sh",
+			in:   "HOST=twl-live # Line-marker: Substitute hostname\nUSERS=() # Line-marker: Substitute users\n",
+			want: "HOST='${builtins.exec \"rm\"}'\nUSERS=('alice' 'bob')\n",
+		},
+		{
+			name: "lua",
+			file: "subst.lua",
+			in:   "local host = \"twl-live\" -- Line-marker: Substitute hostname\n",
+			want: "local host = \"${builtins.exec \\\"rm\\\"}\"\n",
+		},
+		{
+			name: "python",
+			file: "subst.py",
+			in:   "SSH = False  # Line-marker: Substitute ssh\nUSERS = []  # Line-marker: Substitute users\n",
+			want: "SSH = True\nUSERS = [\"alice\", \"bob\"]\n",
+		},
+		{
+			name:    "unsupported file",
+			file:    "subst.conf",
+			in:      "host = twl # Line-marker: Substitute hostname\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			in:      "# Start-marker: Substitute password\n\"\"\n# End-marker: Substitute password\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, _ := ioutil.TempDir("", "")
+			defer os.RemoveAll(dir)
+			file := tc.file
+			if file == "" {
+				file = "subst.nix"
+			}
+			p := path.Join(dir, file)
+			ioutil.WriteFile(p, []byte(tc.in), 0644)
+
+			err := (&Applyer{Run: run}).applyFile(p, 0)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("applyFile() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if b, _ := ioutil.ReadFile(p); string(b) != tc.want {
+				t.Errorf("content = %q, want %q", b, tc.want)
+			}
+		})
+	}
+}
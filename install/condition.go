@@ -0,0 +1,273 @@
+package install
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Conditions select sections of the configuration based on the install
+// settings, in markers such as:
+//
+//   # Start-marker: If autologin && !ssh
+//   # Line-marker: Unless hardware=lenovo-*
+//
+// A field on its own is true if it is set. field=glob is true if any of its
+// values match the glob, and field!=glob if none do. Conditions combine
+// with !, && and ||, and may be grouped in parentheses. Globs containing
+// spaces or operators must be double quoted.
+
+// conditionFields are the settings available to conditions, as functions
+// returning their values. A field is unset if it has no values.
+var conditionFields = map[string]func(s *Settings) []string{
+	"autologin": func(s *Settings) []string { return boolValue(s.Autologin) },
+	// The root filesystem is always in a LUKS container.
+	"encrypted": func(s *Settings) []string { return boolValue(true) },
+	"flake":     func(s *Settings) []string { return boolValue(s.Flake) },
+	"offline":   func(s *Settings) []string { return boolValue(s.Offline.Enable) },
+	"scrub":     func(s *Settings) []string { return boolValue(s.Scrub) },
+	"ssh":       func(s *Settings) []string { return boolValue(s.SSH.Enable) },
+
+	"hostname": func(s *Settings) []string { return stringValue(s.Hostname) },
+	"username": func(s *Settings) []string { return stringValue(s.Username) },
+	"timezone": func(s *Settings) []string { return stringValue(s.Timezone) },
+	"locale":   func(s *Settings) []string { return stringValue(s.Locale) },
+	"keyboard": func(s *Settings) []string { return stringValue(s.Keyboard.Layout) },
+
+	// Hardware profiles match by path, such as lenovo/thinkpad/x230, or with
+	// dashes in place of slashes, such as lenovo-thinkpad-x230.
+	"hardware": func(s *Settings) []string {
+		var out []string
+		for _, imp := range s.NixosHardwareImports {
+			out = append(out, imp, strings.Replace(imp, "/", "-", -1))
+		}
+		return out
+	},
+	"users": func(s *Settings) []string {
+		var out []string
+		for _, u := range s.AllUsers() {
+			out = append(out, u.Name)
+		}
+		return out
+	},
+	"packages": func(s *Settings) []string { return s.Extra.Packages },
+}
+
+func boolValue(b bool) []string {
+	if b {
+		return []string{"true"}
+	}
+	return nil
+}
+
+func stringValue(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// condition is a parsed marker condition.
+type condition interface {
+	eval(s *Settings) bool
+}
+
+type (
+	notCond struct{ c condition }
+	andCond struct{ x, y condition }
+	orCond  struct{ x, y condition }
+	// fieldCond tests a field is set, or compares its values to a glob.
+	fieldCond struct {
+		field  string
+		op     string // "", "=" or "!="
+		glob   string
+		values func(s *Settings) []string
+	}
+)
+
+func (c notCond) eval(s *Settings) bool { return !c.c.eval(s) }
+func (c andCond) eval(s *Settings) bool { return c.x.eval(s) && c.y.eval(s) }
+func (c orCond) eval(s *Settings) bool  { return c.x.eval(s) || c.y.eval(s) }
+
+func (c fieldCond) eval(s *Settings) bool {
+	values := c.values(s)
+	if c.op == "" {
+		return len(values) > 0
+	}
+	matched := false
+	for _, v := range values {
+		if ok, _ := path.Match(c.glob, v); ok {
+			matched = true
+			break
+		}
+	}
+	return matched == (c.op == "=")
+}
+
+// conditionParser is a recursive descent parser of conditions.
+type conditionParser struct {
+	tokens []string
+	pos    int
+}
+
+// tokenizeCondition splits a condition into operators, parentheses and
+// words.
+func tokenizeCondition(s string) ([]string, error) {
+	var out []string
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.HasPrefix(s[i:], "&&"), strings.HasPrefix(s[i:], "||"), strings.HasPrefix(s[i:], "!="):
+			out = append(out, s[i:i+2])
+			i += 2
+		case c == '(' || c == ')' || c == '!' || c == '=':
+			out = append(out, s[i:i+1])
+			i++
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote at offset %d", i)
+			}
+			// Quotes are kept, so the parser knows it is a word.
+			out = append(out, s[i:i+end+2])
+			i += end + 2
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t()!=&|\"", rune(s[i])) {
+				i++
+			}
+			if i == start {
+				return nil, fmt.Errorf("unexpected %q at offset %d", s[i], i)
+			}
+			out = append(out, s[start:i])
+		}
+	}
+	return out, nil
+}
+
+// parseCondition parses a condition, returning an error if it is malformed
+// or refers to an unknown field.
+func parseCondition(s string) (condition, error) {
+	tokens, err := tokenizeCondition(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty condition")
+	}
+	p := conditionParser{tokens: tokens}
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return c, nil
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *conditionParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *conditionParser) parseOr() (condition, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = orCond{x, y}
+	}
+	return x, nil
+}
+
+func (p *conditionParser) parseAnd() (condition, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = andCond{x, y}
+	}
+	return x, nil
+}
+
+func (p *conditionParser) parseUnary() (condition, error) {
+	switch t := p.next(); t {
+	case "!":
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notCond{c}, nil
+	case "(":
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return c, nil
+	default:
+		return p.parseField(t)
+	}
+}
+
+func (p *conditionParser) parseField(name string) (condition, error) {
+	if !isConditionWord(name) || strings.HasPrefix(name, `"`) {
+		if name == "" {
+			return nil, fmt.Errorf("unexpected end of condition")
+		}
+		return nil, fmt.Errorf("expected a field, got %q", name)
+	}
+	values, ok := conditionFields[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q", name)
+	}
+	c := fieldCond{field: name, values: values}
+
+	if op := p.peek(); op == "=" || op == "!=" {
+		p.next()
+		glob := p.next()
+		if !isConditionWord(glob) {
+			return nil, fmt.Errorf("expected a value after %s%s", name, op)
+		}
+		glob = strings.Trim(glob, `"`)
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", glob)
+		}
+		c.op, c.glob = op, glob
+	}
+	return c, nil
+}
+
+// isConditionWord returns true if the token is a word rather than an
+// operator.
+func isConditionWord(t string) bool {
+	switch t {
+	case "", "(", ")", "!", "=", "!=", "&&", "||":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package install
+
+import "testing"
+
+func TestConditions(t *testing.T) {
+	s := &Settings{
+		Username:             "alice",
+		Hostname:             "work laptop",
+		Autologin:            true,
+		NixosHardwareImports: HardwareImports{"lenovo/thinkpad/x230", "common/gpu/intel"},
+		Users:                []User{{Name: "bob"}},
+	}
+
+	tcs := []struct {
+		cond string
+		want bool
+	}{
+		{"autologin", true},
+		{"ssh", false},
+		{"encrypted", true},
+		{"!ssh", true},
+		{"autologin && ssh", false},
+		{"autologin || ssh", true},
+		{"!(autologin && ssh)", true},
+		{"ssh || offline || autologin && !flake", true},
+		{"hardware", true},
+		{"hardware=lenovo-*", true},
+		{"hardware=lenovo/*/x230", true},
+		{"hardware=dell-*", false},
+		{"hardware!=dell-*", true},
+		{"hardware != lenovo-*", false},
+		{"users=bob", true},
+		{"users=alice", true},
+		{"users=carol", false},
+		{`hostname="work laptop"`, true},
+		{"locale", false},
+		{"locale!=de_*", true},
+		{"autologin=true", true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.cond, func(t *testing.T) {
+			c, err := parseCondition(tc.cond)
+			if err != nil {
+				t.Fatalf("parseCondition() failed: %v", err)
+			}
+			if got := c.eval(s); got != tc.want {
+				t.Errorf("eval() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConditionErrors(t *testing.T) {
+	for _, cond := range []string{
+		"",
+		"colour",
+		"autologin &&",
+		"autologin & ssh",
+		"(autologin",
+		"autologin)",
+		"hardware=",
+		"hardware=[",
+		`hostname="work`,
+		"= lenovo",
+		"autologin ssh",
+	} {
+		t.Run(cond, func(t *testing.T) {
+			if _, err := parseCondition(cond); err == nil {
+				t.Errorf("parseCondition(%q) succeeded", cond)
+			}
+		})
+	}
+}
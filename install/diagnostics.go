@@ -0,0 +1,163 @@
+package install
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dmesgTailLines is the number of kernel log lines included in diagnostics.
+const dmesgTailLines = 400
+
+var hashedPasswordExp = regexp.MustCompile(`(hashedPassword\s*=\s*)"[^"]*"`)
+
+// diagnosticsBundle accumulates files into a gzipped tarball.
+type diagnosticsBundle struct {
+	tw  *tar.Writer
+	now time.Time
+}
+
+func (b *diagnosticsBundle) add(name string, data []byte) error {
+	if err := b.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: b.now,
+	}); err != nil {
+		return err
+	}
+	_, err := b.tw.Write(data)
+	return err
+}
+
+// addCmd records the output of the given command. Failures are recorded
+// in the bundle rather than returned, as the system may be in any state.
+func (b *diagnosticsBundle) addCmd(name string, args ...string) error {
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		out = append(out, []byte(fmt.Sprintf("\n%v: %v\n", args, err))...)
+	}
+	return b.add(name, out)
+}
+
+func (b *diagnosticsBundle) addFile(name, path string) error {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		d = []byte(fmt.Sprintf("reading %s: %v\n", path, err))
+	}
+	return b.add(name, d)
+}
+
+// DiagnosticsPath returns where the diagnostics bundle is written should
+// the installation fail.
+func (r *Run) DiagnosticsPath() string {
+	if r.config.DiagnosticsPath != "" {
+		return r.config.DiagnosticsPath
+	}
+	return strings.TrimSuffix(r.log.path, ".log") + "-diagnostics.tar.gz"
+}
+
+// CollectDiagnostics writes a gzipped tarball describing the state of the
+// installation and the target disk to path, to help debug a failure.
+// Secrets are redacted from the settings and generated configuration.
+func (r *Run) CollectDiagnostics(path string) error {
+	return r.collectDiagnostics(path, "/mnt")
+}
+
+// collectDiagnostics writes the diagnostics bundle for an install to the
+// system mounted at mountBase.
+func (r *Run) collectDiagnostics(path, mountBase string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	b := diagnosticsBundle{tw: tar.NewWriter(gz), now: time.Now()}
+
+	if err := r.writeDiagnostics(&b, mountBase); err != nil {
+		return err
+	}
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (r *Run) writeDiagnostics(b *diagnosticsBundle, mountBase string) error {
+	if err := r.log.flush(); err != nil {
+		return err
+	}
+	if err := b.addFile("install.log", r.log.path); err != nil {
+		return err
+	}
+
+	settings, err := json.MarshalIndent(r.config.Redacted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := b.add("settings.json", settings); err != nil {
+		return err
+	}
+	disk, err := json.MarshalIndent(r.config.Disk, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := b.add("disk.json", disk); err != nil {
+		return err
+	}
+
+	if err := b.addCmd("lsblk.json", "lsblk", "-J", "-O", "-p", r.config.Disk.Path); err != nil {
+		return err
+	}
+	for _, dev := range []string{
+		r.config.Disk.Path,
+		r.config.Disk.PathForPartition(1),
+		r.config.Disk.PathForPartition(2),
+		"/dev/mapper/cryptroot",
+	} {
+		if err := b.addCmd("udevadm/"+filepath.Base(dev)+".txt", "udevadm", "info", "-q", "all", "--name", dev); err != nil {
+			return err
+		}
+	}
+	if err := b.addCmd("cryptsetup-status.txt", "sudo", "cryptsetup", "status", "cryptroot"); err != nil {
+		return err
+	}
+	if err := b.addFile("mounts.txt", "/proc/mounts"); err != nil {
+		return err
+	}
+
+	dmesg, err := exec.Command("sudo", "dmesg").CombinedOutput()
+	if err != nil {
+		dmesg = append(dmesg, []byte(fmt.Sprintf("\ndmesg: %v\n", err))...)
+	}
+	if lines := strings.Split(string(dmesg), "\n"); len(lines) > dmesgTailLines {
+		dmesg = []byte(strings.Join(lines[len(lines)-dmesgTailLines:], "\n"))
+	}
+	if err := b.add("dmesg.txt", dmesg); err != nil {
+		return err
+	}
+
+	nixFiles, _ := filepath.Glob(filepath.Join(mountBase, "etc", "nixos", "*.nix"))
+	for _, p := range nixFiles {
+		d, err := ioutil.ReadFile(p)
+		if err != nil {
+			d = []byte(fmt.Sprintf("reading %s: %v\n", p, err))
+		}
+		if err := b.add("nixos/"+filepath.Base(p), hashedPasswordExp.ReplaceAll(d, []byte(`$1"<redacted>"`))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package install
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCollectDiagnostics(t *testing.T) {
+	d, err := ioutil.ReadFile("testdata/diagnostics/settings.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config Settings
+	if err := json.Unmarshal(d, &config); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	log, err := openInstallLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	r := &Run{config: config, log: log}
+	r.log.Write(Update{Msg: "Formatting disk\n"})
+
+	path := filepath.Join(dir, "diagnostics.tar.gz")
+	if err := r.collectDiagnostics(path, "testdata/diagnostics"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[h.Name] = string(b)
+	}
+
+	for _, name := range []string{"install.log", "settings.json", "disk.json", "lsblk.json", "mounts.txt", "dmesg.txt", "nixos/users.nix"} {
+		if _, ok := entries[name]; !ok {
+			t.Errorf("bundle is missing %s", name)
+		}
+	}
+	if !strings.Contains(entries["install.log"], "Formatting disk") {
+		t.Errorf("install.log = %q, want the install log", entries["install.log"])
+	}
+	if got := strings.Count(entries["nixos/users.nix"], `hashedPassword = "<redacted>";`); got != 2 {
+		t.Errorf("nixos/users.nix has %d redacted hashes, want 2:\n%s", got, entries["nixos/users.nix"])
+	}
+
+	for name, contents := range entries {
+		for _, secret := range []string{"hunter2", "swordfish", "carolhash", "alicehash", "bobhash", "$6$"} {
+			if strings.Contains(contents, secret) {
+				t.Errorf("%s contains secret %q", name, secret)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package install
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// stderrTailLines is the number of lines of output kept for a CommandError.
+const stderrTailLines = 20
+
+// FailureClass categorizes the cause of a failed install.
+type FailureClass uint8
+
+// Valid FailureClass values.
+const (
+	FailureInternal FailureClass = iota
+	FailureCommand
+	FailureDisk
+	FailureNetwork
+	FailureConfig
+)
+
+func (c FailureClass) String() string {
+	switch c {
+	case FailureInternal:
+		return "internal"
+	case FailureCommand:
+		return "command"
+	case FailureDisk:
+		return "disk"
+	case FailureNetwork:
+		return "network"
+	case FailureConfig:
+		return "config"
+	}
+	return fmt.Sprintf("FailureClass(%d)", uint8(c))
+}
+
+// CommandError describes a command which did not complete successfully.
+type CommandError struct {
+	Argv     []string
+	ExitCode int
+	// StderrTail holds the last lines the command wrote to stderr.
+	StderrTail string
+	Err        error
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("%s: %v", strings.Join(e.Argv, " "), e.Err)
+	if e.ExitCode > 0 {
+		msg = fmt.Sprintf("%s: exit status %d", strings.Join(e.Argv, " "), e.ExitCode)
+	}
+	if lines := strings.Split(strings.TrimSpace(e.StderrTail), "\n"); lines[len(lines)-1] != "" {
+		msg += " (" + strings.TrimSpace(lines[len(lines)-1]) + ")"
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// program returns the name of the binary invoked, ignoring sudo.
+func (e *CommandError) program() string {
+	for _, arg := range e.Argv {
+		if arg != "sudo" {
+			return filepath.Base(arg)
+		}
+	}
+	return ""
+}
+
+// StepError describes the failure of an install step.
+type StepError struct {
+	Step, StepName string
+	Class          FailureClass
+	Err            error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step %q failed: %v", e.StepName, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// Explain returns a sentence describing the likely cause of the failure
+// and what the user can do about it.
+func (e *StepError) Explain() string {
+	switch e.Class {
+	case FailureDisk:
+		return "The target disk could not be set up. It may be in use, failing, or too small."
+	case FailureNetwork:
+		return "Packages could not be downloaded. Check the network connection and retry."
+	case FailureConfig:
+		return "The generated system configuration failed to build."
+	case FailureCommand:
+		return "A command run by the installer failed. The log has its full output."
+	}
+	return "The installer encountered an unexpected error."
+}
+
+// Command returns the failed command, if the failure was due to one.
+func (e *StepError) Command() *CommandError {
+	var ce *CommandError
+	if errors.As(e.Err, &ce) {
+		return ce
+	}
+	return nil
+}
+
+var (
+	diskPrograms = map[string]bool{
+		"parted": true, "partprobe": true, "mkfs.fat": true, "mkfs.ext4": true,
+		"cryptsetup": true, "dd": true, "mount": true, "blockdev": true,
+	}
+	networkErrors = []string{
+		"unable to download", "Could not resolve host", "Couldn't resolve host",
+		"Connection timed out", "Network is unreachable", "Timeout was reached",
+	}
+)
+
+// classifyFailure makes a best guess at the cause of a failure.
+func classifyFailure(err error) FailureClass {
+	var ce *CommandError
+	if !errors.As(err, &ce) {
+		return FailureInternal
+	}
+
+	switch prog := ce.program(); {
+	case diskPrograms[prog]:
+		return FailureDisk
+	case prog == "nixos-install":
+		for _, s := range networkErrors {
+			if strings.Contains(ce.StderrTail, s) {
+				return FailureNetwork
+			}
+		}
+		if strings.Contains(ce.StderrTail, "error:") {
+			return FailureConfig
+		}
+	}
+	return FailureCommand
+}
+
+// tailWriter keeps the last lines written to it.
+type tailWriter struct {
+	max   int
+	lines []string
+	part  string
+}
+
+func (t *tailWriter) Write(in []byte) (int, error) {
+	parts := strings.Split(t.part+string(in), "\n")
+	t.part = parts[len(parts)-1]
+	// Only keep the latest redraw of a progress line.
+	if idx := strings.LastIndex(t.part, "\r"); idx >= 0 {
+		t.part = t.part[idx+1:]
+	}
+	for _, l := range parts[:len(parts)-1] {
+		if l = strings.TrimRight(l, "\r"); l == "" {
+			continue
+		}
+		t.lines = append(t.lines, l)
+	}
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+	return len(in), nil
+}
+
+func (t *tailWriter) String() string {
+	lines := t.lines
+	if t.part != "" {
+		lines = append(lines, t.part)
+	}
+	return strings.Join(lines, "\n")
+}
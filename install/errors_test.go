@@ -0,0 +1,48 @@
+package install
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	tcs := []struct {
+		name string
+		err  error
+		want FailureClass
+	}{
+		{"not a command", errors.New("template parse"), FailureInternal},
+		{"parted", &CommandError{Argv: []string{"sudo", "parted", "--script"}, ExitCode: 1}, FailureDisk},
+		{"wrapped mkfs", fmt.Errorf("formatting: %w", &CommandError{Argv: []string{"sudo", "mkfs.ext4"}, ExitCode: 1}), FailureDisk},
+		{
+			"nixos-install download",
+			&CommandError{Argv: []string{"sudo", "nixos-install"}, ExitCode: 1, StderrTail: "error: unable to download 'https://cache.nixos.org/x': Couldn't resolve host name (6)"},
+			FailureNetwork,
+		},
+		{
+			"nixos-install eval",
+			&CommandError{Argv: []string{"sudo", "nixos-install"}, ExitCode: 1, StderrTail: "error: undefined variable 'foo'"},
+			FailureConfig,
+		},
+		{"cp", &CommandError{Argv: []string{"cp", "-arv"}, ExitCode: 1}, FailureCommand},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := classifyFailure(tc.err); got != tc.want {
+				t.Errorf("classifyFailure() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTailWriter(t *testing.T) {
+	tw := &tailWriter{max: 2}
+	tw.Write([]byte("one\ntwo\nthr"))
+	tw.Write([]byte("ee\n10 bytes\r20 bytes\r30"))
+
+	if got, want := tw.String(), "two\nthree\n30"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,178 @@
+package install
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/twitchylinux/twlinst/nix"
+)
+
+// extraDir is where extra modules are placed, relative to configuration.nix.
+const extraDir = "extra"
+
+// ExtraConfig is configuration added to the installed system beyond what
+// the installer generates.
+type ExtraConfig struct {
+	// Packages are attribute paths in nixpkgs, such as git or
+	// python3Packages.requests, added to environment.systemPackages.
+	Packages []string `json:"packages"`
+	// Modules are paths to Nix modules on the install medium, which are
+	// copied to the installed system and imported.
+	Modules []string `json:"modules"`
+	// Snippets are Nix modules given inline, such as
+	// "{ services.printing.enable = true; }".
+	Snippets []string `json:"snippets"`
+}
+
+var (
+	packageExp     = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_'\-]*(\.[a-zA-Z_][a-zA-Z0-9_'\-]*)*$`)
+	snippetNameExp = regexp.MustCompile(`^snippet-[0-9]+\.nix$`)
+)
+
+// Validate returns an error if the extra configuration is malformed. The
+// packages are checked against nixpkgs by ExtraCheckStep.
+func (e *ExtraConfig) Validate() error {
+	for _, p := range e.Packages {
+		if !packageExp.MatchString(p) {
+			return fmt.Errorf("invalid package name %q", p)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, m := range e.Modules {
+		if !filepath.IsAbs(m) {
+			return fmt.Errorf("module path %q is not absolute", m)
+		}
+		name := filepath.Base(m)
+		switch {
+		case filepath.Ext(name) != ".nix":
+			return fmt.Errorf("module %s is not a .nix file", m)
+		case snippetNameExp.MatchString(name):
+			return fmt.Errorf("module name %s is reserved for snippets", name)
+		case seen[name]:
+			return fmt.Errorf("more than one module is named %s", name)
+		}
+		seen[name] = true
+
+		fi, err := os.Stat(m)
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("module %s is not a file", m)
+		}
+	}
+
+	for i, s := range e.Snippets {
+		if strings.TrimSpace(s) == "" {
+			return fmt.Errorf("snippet %d is empty", i+1)
+		}
+	}
+	return nil
+}
+
+// snippetName returns the file name snippet i is written to.
+func snippetName(i int) string {
+	return fmt.Sprintf("snippet-%d.nix", i+1)
+}
+
+// imports returns the paths configuration.nix imports for the extra
+// modules and snippets.
+func (e *ExtraConfig) imports() []nix.Expr {
+	var out []nix.Expr
+	for _, m := range e.Modules {
+		out = append(out, nix.Path("./"+extraDir+"/"+filepath.Base(m)))
+	}
+	for i := range e.Snippets {
+		out = append(out, nix.Path("./"+extraDir+"/"+snippetName(i)))
+	}
+	return out
+}
+
+// packagesNix returns the list of packages for environment.systemPackages.
+func (e *ExtraConfig) packagesNix() nix.List {
+	out := make(nix.List, len(e.Packages))
+	for i, p := range e.Packages {
+		out[i] = nix.Select{Expr: nix.Raw("pkgs"), Path: strings.Split(p, ".")}
+	}
+	return out
+}
+
+// writeExtraFiles copies the extra modules and writes the snippets into the
+// extra directory under nixosDir.
+func writeExtraFiles(updateChan chan Update, e *ExtraConfig, nixosDir string) error {
+	if len(e.Modules) == 0 && len(e.Snippets) == 0 {
+		return nil
+	}
+	dir := filepath.Join(nixosDir, extraDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if len(e.Modules) > 0 {
+		args := append([]string{"-v"}, e.Modules...)
+		if _, err := runCmdOutput(updateChan, exec.Command("cp", append(args, dir)...)); err != nil {
+			return fmt.Errorf("copying modules: %w", err)
+		}
+	}
+	for i, s := range e.Snippets {
+		if err := ioutil.WriteFile(filepath.Join(dir, snippetName(i)), []byte(s+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// missingPackagesExpr returns a Nix expression evaluating to the packages
+// which are not derivations in nixpkgs.
+func missingPackagesExpr(packages []string) string {
+	return "let pkgs = import <nixpkgs> {}; inherit (pkgs) lib; in builtins.filter " +
+		"(p: !lib.isDerivation (lib.attrByPath (lib.splitString \".\" p) null pkgs)) " +
+		strings.TrimSpace(string(nix.Format(nix.StringList(packages))))
+}
+
+// ExtraCheckStep verifies the extra packages exist in the nixpkgs of the
+// live system and the snippets parse, before the disk is touched.
+type ExtraCheckStep struct{}
+
+func (s *ExtraCheckStep) Exec(updateChan chan Update, run *Run) error {
+	extra := &run.config.Extra
+
+	for i, snippet := range extra.Snippets {
+		if _, err := runCmdOutput(updateChan, exec.Command("nix-instantiate", "--parse", "-E", snippet)); err != nil {
+			return fmt.Errorf("parsing snippet %d: %w", i+1, err)
+		}
+	}
+
+	if len(extra.Packages) == 0 {
+		return nil
+	}
+	progressInfo(updateChan, "  Checking %d packages are in nixpkgs.\n", len(extra.Packages))
+	out, err := runCmdOutput(updateChan, exec.Command("nix-instantiate", "--eval", "--strict", "--json", "-E", missingPackagesExpr(extra.Packages)))
+	if err != nil {
+		return fmt.Errorf("evaluating packages: %w", err)
+	}
+	// Warnings from evaluation are on earlier lines.
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	var missing []string
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &missing); err != nil {
+		return fmt.Errorf("reading evaluation result: %v", err)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("packages not found in nixpkgs: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (s *ExtraCheckStep) Name() string {
+	return "Check extra configuration"
+}
+
+func (s *ExtraCheckStep) Stage() string {
+	return "check"
+}
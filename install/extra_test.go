@@ -0,0 +1,57 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtraConfigValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twlinst-extra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	module := filepath.Join(dir, "team.nix")
+	if err := ioutil.WriteFile(module, []byte("{ }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modDir := filepath.Join(dir, "mods.nix")
+	if err := os.Mkdir(modDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tcs := []struct {
+		name    string
+		extra   ExtraConfig
+		wantErr string // a substring of the error, if any
+	}{
+		{"empty", ExtraConfig{}, ""},
+		{"packages", ExtraConfig{Packages: []string{"git", "python3Packages.requests", "gnome.gnome-tweaks"}}, ""},
+		{"package with space", ExtraConfig{Packages: []string{"git vim"}}, "invalid package"},
+		{"package expression", ExtraConfig{Packages: []string{"(import ./evil.nix)"}}, "invalid package"},
+		{"package trailing dot", ExtraConfig{Packages: []string{"python3Packages."}}, "invalid package"},
+		{"module", ExtraConfig{Modules: []string{module}}, ""},
+		{"relative module", ExtraConfig{Modules: []string{"team.nix"}}, "not absolute"},
+		{"missing module", ExtraConfig{Modules: []string{filepath.Join(dir, "missing.nix")}}, "no such file"},
+		{"directory module", ExtraConfig{Modules: []string{modDir}}, "is not a file"},
+		{"duplicate module", ExtraConfig{Modules: []string{module, filepath.Join(dir, "sub", "team.nix")}}, "more than one module"},
+		{"reserved module name", ExtraConfig{Modules: []string{filepath.Join(dir, "snippet-1.nix")}}, "reserved for snippets"},
+		{"snippet", ExtraConfig{Snippets: []string{"{ services.printing.enable = true; }"}}, ""},
+		{"blank snippet", ExtraConfig{Snippets: []string{" \n"}}, "is empty"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.extra.Validate()
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("Validate() = %v, want nil", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("Validate() = %v, want error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
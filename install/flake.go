@@ -0,0 +1,175 @@
+package install
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/twitchylinux/twlinst/nix"
+)
+
+// Names of the flake inputs for the local sources. In flake mode they are
+// also the module arguments configuration.nix uses to refer to them.
+const (
+	twlBaseInput  = "twl-base"
+	hardwareInput = "nixos-hardware"
+)
+
+// Paths of the local sources on the install medium.
+const (
+	twlBaseSource  = "/etc/twl-base"
+	hardwareSource = "/etc/nixos-hardware"
+)
+
+// flakeFeatures enables the experimental Nix features flakes need.
+var flakeFeatures = []string{"--extra-experimental-features", "nix-command flakes"}
+
+// sourcePath returns an expression for a path within one of the local
+// sources, as seen from configuration.nix. rel is empty or begins with a
+// slash.
+func sourcePath(c *Settings, input, rel string) nix.Expr {
+	if !c.Flake {
+		return nix.Path("../" + input + rel)
+	}
+	if rel == "" {
+		return nix.Raw(input)
+	}
+	return nix.Add{X: nix.Raw(input), Y: nix.String(rel)}
+}
+
+// flakeNix returns the contents of flake.nix, which builds the system from
+// configuration.nix using nixpkgs at nixpkgsPath.
+func flakeNix(c *Settings, nixpkgsPath string) nix.Expr {
+	localInput := func(path string) nix.AttrSet {
+		return nix.AttrSet{
+			nix.A(nix.String("path:"+path), "url"),
+			nix.A(nix.Bool(false), "flake"),
+		}
+	}
+
+	system := nix.Apply{
+		Fn: nix.Raw("nixpkgs.lib.nixosSystem"),
+		Args: []nix.Expr{nix.AttrSet{
+			nix.A(nix.AttrSet{
+				nix.A(nix.Select{Expr: nix.Raw(twlBaseInput), Path: []string{"outPath"}}, twlBaseInput),
+				nix.A(nix.Select{Expr: nix.Raw(hardwareInput), Path: []string{"outPath"}}, hardwareInput),
+			}, "specialArgs"),
+			nix.A(nix.List{nix.Path("./configuration.nix")}, "modules"),
+		}},
+	}
+
+	return nix.AttrSet{
+		nix.A(nix.String("TwitchyLinux system configuration"), "description"),
+		nix.Attr{
+			Key:     []string{"inputs", "nixpkgs", "url"},
+			Value:   nix.String("path:" + nixpkgsPath),
+			Comment: "The sources the system was installed from. Run nix flake update to\nrelock them after changing the local copies.",
+		},
+		nix.A(localInput(twlBaseSource), "inputs", twlBaseInput),
+		nix.A(localInput(hardwareSource), "inputs", hardwareInput),
+		nix.A(nix.Func{
+			Args: []string{"self", "nixpkgs", twlBaseInput, hardwareInput},
+			Body: nix.AttrSet{
+				nix.A(system, "nixosConfigurations", c.Hostname),
+			},
+		}, "outputs"),
+	}
+}
+
+// nixpkgsSource returns the nixpkgs of the live system, resolving the
+// channel symlinks so the flake input is a stable store path.
+func nixpkgsSource(updateChan chan Update) (string, error) {
+	out, err := runCmdOutput(updateChan, exec.Command("nix-instantiate", "--find-file", "nixpkgs"))
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(strings.TrimSpace(string(out)))
+}
+
+// defaultPlatform returns the Nix platform of the running system.
+func defaultPlatform() string {
+	switch runtime.GOARCH {
+	case "arm64":
+		return "aarch64-linux"
+	case "386":
+		return "i686-linux"
+	default:
+		return "x86_64-linux"
+	}
+}
+
+// relocateFlakeLock rewrites the paths of local inputs in flake.lock data,
+// keeping their hashes.
+func relocateFlakeLock(data []byte, paths map[string]string) ([]byte, error) {
+	var lock map[string]interface{}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, err
+	}
+	nodes, _ := lock["nodes"].(map[string]interface{})
+	for input, path := range paths {
+		node, ok := nodes[input].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("input %s is not locked", input)
+		}
+		for _, key := range []string{"locked", "original"} {
+			ref, ok := node[key].(map[string]interface{})
+			if !ok || ref["type"] != "path" {
+				return nil, fmt.Errorf("input %s is not a path", input)
+			}
+			ref["path"] = path
+		}
+	}
+	out, err := json.MarshalIndent(lock, "", "  ")
+	return append(out, '\n'), err
+}
+
+// setupFlake writes flake.nix to the configuration directory and locks its
+// inputs. The local sources are hashed from the copies alongside nixosDir,
+// as modified for the install, then relocated to the paths in flake.nix.
+// Nix uses the copies already in the store when installing, and the
+// sources at those paths on the installed system.
+func setupFlake(updateChan chan Update, run *Run, nixosDir string) error {
+	progressInfo(updateChan, "  Writing flake.nix.\n")
+	// The hostname names the system in the flake.
+	if err := ValidateHostname(run.config.Hostname); err != nil {
+		return err
+	}
+
+	nixpkgs, err := nixpkgsSource(updateChan)
+	if err != nil {
+		return fmt.Errorf("finding nixpkgs: %w", err)
+	}
+	if err := writeNixFile(filepath.Join(nixosDir, "flake.nix"), "", flakeNix(&run.config, nixpkgs)); err != nil {
+		return fmt.Errorf("writing flake: %v", err)
+	}
+
+	progressInfo(updateChan, "  Locking flake inputs.\n")
+	args := append(append([]string{}, flakeFeatures...), "flake", "lock", "path:"+nixosDir)
+	sources := map[string]string{twlBaseInput: twlBaseSource, hardwareInput: hardwareSource}
+	for _, input := range []string{twlBaseInput, hardwareInput} {
+		args = append(args, "--override-input", input, "path:"+filepath.Join(filepath.Dir(nixosDir), input))
+	}
+	args = append(args, run.config.Offline.nixOptions()...)
+	if _, err := runCmdOutput(updateChan, exec.Command("nix", args...)); err != nil {
+		return fmt.Errorf("locking flake: %w", err)
+	}
+
+	lockPath := filepath.Join(nixosDir, "flake.lock")
+	data, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		return err
+	}
+	if data, err = relocateFlakeLock(data, sources); err != nil {
+		return fmt.Errorf("relocating flake inputs: %v", err)
+	}
+	return ioutil.WriteFile(lockPath, data, 0644)
+}
+
+// flakeRef returns the reference to the system in the installed flake.
+func flakeRef(c *Settings, mountBase string) string {
+	return filepath.Join(mountBase, "etc", "nixos") + "#" + c.Hostname
+}
@@ -0,0 +1,49 @@
+package install
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRelocateFlakeLock(t *testing.T) {
+	in := `{
+  "nodes": {
+    "nixpkgs": {
+      "locked": {"lastModified": 1, "narHash": "sha256-pkgs", "path": "/nix/store/abc-nixos/nixos", "type": "path"},
+      "original": {"path": "/nix/store/abc-nixos/nixos", "type": "path"}
+    },
+    "root": {"inputs": {"nixpkgs": "nixpkgs", "twl-base": "twl-base"}},
+    "twl-base": {
+      "flake": false,
+      "locked": {"lastModified": 2, "narHash": "sha256-base", "path": "/mnt/etc/twl-base", "type": "path"},
+      "original": {"path": "/mnt/etc/twl-base", "type": "path"}
+    }
+  },
+  "root": "root",
+  "version": 7
+}`
+
+	out, err := relocateFlakeLock([]byte(in), map[string]string{"twl-base": "/etc/twl-base"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want map[string]interface{}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal([]byte(in), &want)
+	base := want["nodes"].(map[string]interface{})["twl-base"].(map[string]interface{})
+	base["locked"].(map[string]interface{})["path"] = "/etc/twl-base"
+	base["original"].(map[string]interface{})["path"] = "/etc/twl-base"
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("relocateFlakeLock() = %s", out)
+	}
+
+	if _, err := relocateFlakeLock([]byte(in), map[string]string{"nixos-hardware": "/etc/nixos-hardware"}); err == nil {
+		t.Error("relocateFlakeLock() succeeded for a missing input")
+	}
+	if _, err := relocateFlakeLock([]byte(in), map[string]string{"root": "/etc/root"}); err == nil {
+		t.Error("relocateFlakeLock() succeeded for a node which is not a path")
+	}
+}
@@ -0,0 +1,243 @@
+package install
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// HooksDir holds hook scripts on the install medium. Scripts in a
+// subdirectory named after a phase, such as post-install, run on the live
+// system in lexical order. Scripts in a directory with the .chroot suffix,
+// such as post-install.chroot, run inside the installed system.
+const HooksDir = "/etc/twlinst/hooks.d"
+
+// HookPhase is the point in the install at which a hook runs.
+type HookPhase string
+
+// Phases at which hooks run, in order.
+const (
+	PhasePrePartition  HookPhase = "pre-partition"
+	PhasePostPartition HookPhase = "post-partition"
+	PhasePostConfigure HookPhase = "post-configure"
+	PhasePostInstall   HookPhase = "post-install"
+)
+
+var hookPhases = []HookPhase{PhasePrePartition, PhasePostPartition, PhasePostConfigure, PhasePostInstall}
+
+// HookFailurePolicy describes what happens when a hook fails.
+type HookFailurePolicy string
+
+const (
+	// HookAbort fails the install. It is the default.
+	HookAbort HookFailurePolicy = "abort"
+	// HookContinue logs a warning and carries on.
+	HookContinue HookFailurePolicy = "continue"
+)
+
+// DefaultHookTimeout is the run time limit of a hook in seconds, if it
+// does not set one.
+const DefaultHookTimeout = 600
+
+// chrootHookPath is where a hook is placed to run inside the installed
+// system.
+const chrootHookPath = "/tmp/twlinst-hook"
+
+// Hook is a script run during the install.
+type Hook struct {
+	// Path is the path to an executable on the live system.
+	Path  string    `json:"path"`
+	Phase HookPhase `json:"phase"`
+	// Chroot runs the hook inside the installed system using nixos-enter,
+	// which is only possible once it is installed.
+	Chroot bool `json:"chroot"`
+	// Timeout limits the run time of the hook, in seconds.
+	// DefaultHookTimeout is used if it is zero.
+	Timeout   int               `json:"timeout"`
+	OnFailure HookFailurePolicy `json:"on_failure"`
+}
+
+// Validate returns an error if the hook cannot be run.
+func (h *Hook) Validate() error {
+	known := false
+	for _, p := range hookPhases {
+		known = known || h.Phase == p
+	}
+	switch {
+	case !known:
+		return fmt.Errorf("hook %s: unknown phase %q", h.Path, h.Phase)
+	case h.Chroot && h.Phase != PhasePostInstall:
+		return fmt.Errorf("hook %s: only %s hooks can run in the installed system", h.Path, PhasePostInstall)
+	case h.Timeout < 0:
+		return fmt.Errorf("hook %s: invalid timeout %d", h.Path, h.Timeout)
+	case h.OnFailure != "" && h.OnFailure != HookAbort && h.OnFailure != HookContinue:
+		return fmt.Errorf("hook %s: unknown failure policy %q", h.Path, h.OnFailure)
+	case !filepath.IsAbs(h.Path):
+		return fmt.Errorf("hook path %q is not absolute", h.Path)
+	}
+
+	fi, err := os.Stat(h.Path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() || fi.Mode()&0111 == 0 {
+		return fmt.Errorf("hook %s is not an executable file", h.Path)
+	}
+	return nil
+}
+
+func (h *Hook) timeout() int {
+	if h.Timeout == 0 {
+		return DefaultHookTimeout
+	}
+	return h.Timeout
+}
+
+// LoadHooks returns the hooks in a directory laid out like HooksDir, in
+// phase order. There are no hooks if the directory does not exist.
+func LoadHooks(dir string) ([]Hook, error) {
+	var out []Hook
+	for _, phase := range hookPhases {
+		for _, chroot := range []bool{false, true} {
+			sub := string(phase)
+			if chroot {
+				sub += ".chroot"
+			}
+			files, err := ioutil.ReadDir(filepath.Join(dir, sub))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+			sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+			for _, f := range files {
+				// Allow for READMEs and disabled scripts.
+				if f.IsDir() || f.Mode()&0111 == 0 {
+					continue
+				}
+				out = append(out, Hook{Path: filepath.Join(dir, sub, f.Name()), Phase: phase, Chroot: chroot})
+			}
+		}
+	}
+	return out, nil
+}
+
+// ValidateHooks returns an error if any of the hooks are invalid.
+func (s *Settings) ValidateHooks() error {
+	for i := range s.Hooks {
+		if err := s.Hooks[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hookPhaseSet returns the phases which have hooks.
+func (s *Settings) hookPhaseSet() map[HookPhase]bool {
+	out := map[HookPhase]bool{}
+	for _, h := range s.Hooks {
+		out[h.Phase] = true
+	}
+	return out
+}
+
+// hookEnv returns the environment variables describing the install which
+// are passed to hooks.
+func hookEnv(c *Settings, phase HookPhase, mountBase string) []string {
+	return []string{
+		"TWLINST_PHASE=" + string(phase),
+		"TWLINST_ROOT=" + mountBase,
+		"TWLINST_DISK=" + c.Disk.Path,
+		"TWLINST_HOSTNAME=" + c.Hostname,
+		"TWLINST_USERNAME=" + c.Username,
+	}
+}
+
+// HookStep runs the hooks for a phase of the install.
+type HookStep struct {
+	Phase HookPhase
+}
+
+func (s *HookStep) Exec(updateChan chan Update, run *Run) error {
+	mountBase := "/mnt"
+	for i := range run.config.Hooks {
+		h := &run.config.Hooks[i]
+		if h.Phase != s.Phase {
+			continue
+		}
+		progressInfo(updateChan, "  Running %s.\n", filepath.Base(h.Path))
+		err := s.runHook(updateChan, run, h, mountBase)
+		if err == nil {
+			continue
+		}
+
+		var ce *CommandError
+		if errors.As(err, &ce) && ce.ExitCode == 124 {
+			err = fmt.Errorf("timed out after %ds", h.timeout())
+		}
+		if h.OnFailure == HookContinue {
+			progressWarn(updateChan, "  Hook %s failed, continuing: %v\n", h.Path, err)
+			continue
+		}
+		return fmt.Errorf("hook %s: %w", h.Path, err)
+	}
+	return nil
+}
+
+func (s *HookStep) runHook(updateChan chan Update, run *Run, h *Hook, mountBase string) error {
+	env := hookEnv(&run.config, s.Phase, mountBase)
+	// timeout exits with status 124 if the limit is reached.
+	limit := []string{"timeout", "--kill-after=10", strconv.Itoa(h.timeout())}
+
+	var e *exec.Cmd
+	if h.Chroot {
+		// The script is copied in, as the live system is not visible from
+		// inside the chroot.
+		dest := filepath.Join(mountBase, chrootHookPath)
+		if _, err := runCmdOutput(updateChan, exec.Command("sudo", "install", "-m", "0755", h.Path, dest)); err != nil {
+			return err
+		}
+		defer runCmdOutput(updateChan, exec.Command("sudo", "rm", "-f", dest))
+
+		args := append(append([]string{"sudo"}, limit...), "nixos-enter", "--root", mountBase, "--",
+			"/run/current-system/sw/bin/env")
+		args = append(args, env...)
+		e = exec.Command(args[0], append(args[1:], chrootHookPath)...)
+	} else {
+		e = exec.Command(limit[0], append(limit[1:], h.Path)...)
+		e.Env = append(os.Environ(), env...)
+	}
+
+	e.Stdout = &cmdInteractiveWriter{
+		updateChan: updateChan,
+		logPrefix:  "    ",
+	}
+	e.Stderr = &cmdInteractiveWriter{
+		updateChan: updateChan,
+		logPrefix:  "    ",
+		IsErr:      true,
+	}
+	return runCmd(updateChan, e)
+}
+
+func (s *HookStep) Name() string {
+	return "Run " + string(s.Phase) + " hooks"
+}
+
+// Stage returns the stage of the install the phase is a part of.
+func (s *HookStep) Stage() string {
+	switch s.Phase {
+	case PhasePrePartition, PhasePostPartition:
+		return "format"
+	case PhasePostConfigure:
+		return "configure"
+	default:
+		return "copy"
+	}
+}
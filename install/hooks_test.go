@@ -0,0 +1,173 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeScript writes an executable shell script to path.
+func writeScript(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twlinst-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeScript(t, filepath.Join(dir, "post-install", "20-second"), "true")
+	writeScript(t, filepath.Join(dir, "post-install", "10-first"), "true")
+	writeScript(t, filepath.Join(dir, "post-install.chroot", "10-inside"), "true")
+	writeScript(t, filepath.Join(dir, "pre-partition", "wipe"), "true")
+	if err := ioutil.WriteFile(filepath.Join(dir, "post-install", "README"), []byte("docs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadHooks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Hook{
+		{Path: filepath.Join(dir, "pre-partition", "wipe"), Phase: PhasePrePartition},
+		{Path: filepath.Join(dir, "post-install", "10-first"), Phase: PhasePostInstall},
+		{Path: filepath.Join(dir, "post-install", "20-second"), Phase: PhasePostInstall},
+		{Path: filepath.Join(dir, "post-install.chroot", "10-inside"), Phase: PhasePostInstall, Chroot: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadHooks() = %+v, want %+v", got, want)
+	}
+
+	if got, err := LoadHooks(filepath.Join(dir, "missing")); err != nil || len(got) != 0 {
+		t.Errorf("LoadHooks(missing) = %v, %v, want no hooks", got, err)
+	}
+}
+
+func TestHookValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twlinst-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "hook")
+	writeScript(t, script, "true")
+	notExec := filepath.Join(dir, "notes")
+	if err := ioutil.WriteFile(notExec, []byte("docs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tcs := []struct {
+		name    string
+		hook    Hook
+		wantErr bool
+	}{
+		{"host", Hook{Path: script, Phase: PhasePostPartition}, false},
+		{"chroot", Hook{Path: script, Phase: PhasePostInstall, Chroot: true, Timeout: 30, OnFailure: HookContinue}, false},
+		{"unknown phase", Hook{Path: script, Phase: "mid-install"}, true},
+		{"early chroot", Hook{Path: script, Phase: PhasePostConfigure, Chroot: true}, true},
+		{"negative timeout", Hook{Path: script, Phase: PhasePostInstall, Timeout: -1}, true},
+		{"unknown policy", Hook{Path: script, Phase: PhasePostInstall, OnFailure: "retry"}, true},
+		{"relative", Hook{Path: "hook", Phase: PhasePostInstall}, true},
+		{"missing", Hook{Path: filepath.Join(dir, "missing"), Phase: PhasePostInstall}, true},
+		{"not executable", Hook{Path: notExec, Phase: PhasePostInstall}, true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.hook.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestHookStep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twlinst-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	record := filepath.Join(dir, "record")
+	writeScript(t, record, `echo "$TWLINST_PHASE $TWLINST_HOSTNAME" >> `+out)
+	fail := filepath.Join(dir, "fail")
+	writeScript(t, fail, "echo failing >&2; exit 3")
+	slow := filepath.Join(dir, "slow")
+	writeScript(t, slow, "sleep 10")
+
+	tcs := []struct {
+		name    string
+		hooks   []Hook
+		wantErr string
+		wantOut string
+	}{
+		{
+			name: "runs phase in order",
+			hooks: []Hook{
+				{Path: record, Phase: PhasePostInstall},
+				{Path: fail, Phase: PhasePrePartition},
+				{Path: record, Phase: PhasePostInstall},
+			},
+			wantOut: "post-install twl\npost-install twl\n",
+		},
+		{
+			name: "abort",
+			hooks: []Hook{
+				{Path: fail, Phase: PhasePostInstall},
+				{Path: record, Phase: PhasePostInstall},
+			},
+			wantErr: "exit status 3",
+		},
+		{
+			name: "continue",
+			hooks: []Hook{
+				{Path: fail, Phase: PhasePostInstall, OnFailure: HookContinue},
+				{Path: record, Phase: PhasePostInstall},
+			},
+			wantOut: "post-install twl\n",
+		},
+		{
+			name:    "timeout",
+			hooks:   []Hook{{Path: slow, Phase: PhasePostInstall, Timeout: 1}},
+			wantErr: "timed out after 1s",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Remove(out)
+			updates := make(chan Update)
+			go func() {
+				for range updates {
+				}
+			}()
+			defer close(updates)
+
+			run := &Run{config: Settings{Hostname: "twl", Hooks: tc.hooks}}
+			err := (&HookStep{Phase: PhasePostInstall}).Exec(updates, run)
+			if tc.wantErr == "" && err != nil {
+				t.Fatalf("Exec() failed: %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("Exec() = %v, want error containing %q", err, tc.wantErr)
+			}
+
+			got, _ := ioutil.ReadFile(out)
+			if string(got) != tc.wantOut {
+				t.Errorf("hook output = %q, want %q", got, tc.wantOut)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package install
+
+import (
+	"bytes"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/twitchylinux/twlinst/nix"
+)
+
+// baseInitrdModules are always available in the initrd, so the installed
+// system can boot from common disk controllers and unlock the root
+// filesystem even if it is moved to different hardware.
+var baseInitrdModules = []string{"aesni_intel", "cryptd", "nvme", "ahci", "ata_piix", "uas", "sd_mod", "sr_mod", "xhci_pci", "sdhci_pci"}
+
+// hwConfigHeader precedes the generated hardware-configuration.nix.
+const hwConfigHeader = `# Generated by twlinst from the output of nixos-generate-config.
+# Filesystems are configured in filesystems.nix.
+`
+
+// hardwareConfig holds the settings we take from the hardware
+// configuration detected by nixos-generate-config.
+type hardwareConfig struct {
+	InitrdAvailableModules []string
+	InitrdKernelModules    []string
+	KernelModules          []string
+	// ExtraModulePackages are Nix expressions, such as
+	// config.boot.kernelPackages.broadcom_sta.
+	ExtraModulePackages []string
+	// Microcode lists the CPU vendors to enable microcode updates for.
+	Microcode []string
+	Platform  string
+}
+
+// nixListRe matches the assignment of a list to the given attribute.
+func nixListRe(attr string) *regexp.Regexp {
+	return regexp.MustCompile(`(?s)` + regexp.QuoteMeta(attr) + `\s*=\s*\[(.*?)\]\s*;`)
+}
+
+var (
+	hwInitrdAvailableRe = nixListRe("boot.initrd.availableKernelModules")
+	hwInitrdKernelRe    = nixListRe("boot.initrd.kernelModules")
+	hwKernelModulesRe   = nixListRe("boot.kernelModules")
+	hwExtraPackagesRe   = nixListRe("boot.extraModulePackages")
+	hwMicrocodeRe       = regexp.MustCompile(`hardware\.cpu\.(\w+)\.updateMicrocode\s*=`)
+	hwPlatformRe        = regexp.MustCompile(`nixpkgs\.hostPlatform\s*=\s*(?:lib\.mkDefault\s*)?"([^"]+)"\s*;`)
+	nixStringRe         = regexp.MustCompile(`"([^"]*)"`)
+)
+
+// parseHardwareConfig extracts the kernel modules, microcode and platform
+// settings from the output of nixos-generate-config --show-hardware-config.
+// Filesystem and swap entries are ignored.
+func parseHardwareConfig(src string) *hardwareConfig {
+	var out hardwareConfig
+
+	stringList := func(re *regexp.Regexp) []string {
+		var l []string
+		if m := re.FindStringSubmatch(src); m != nil {
+			for _, s := range nixStringRe.FindAllStringSubmatch(m[1], -1) {
+				l = append(l, s[1])
+			}
+		}
+		return l
+	}
+	out.InitrdAvailableModules = stringList(hwInitrdAvailableRe)
+	out.InitrdKernelModules = stringList(hwInitrdKernelRe)
+	out.KernelModules = stringList(hwKernelModulesRe)
+	if m := hwExtraPackagesRe.FindStringSubmatch(src); m != nil {
+		out.ExtraModulePackages = strings.Fields(m[1])
+	}
+
+	for _, m := range hwMicrocodeRe.FindAllStringSubmatch(src, -1) {
+		out.Microcode = appendUnique(out.Microcode, m[1])
+	}
+	if m := hwPlatformRe.FindStringSubmatch(src); m != nil {
+		out.Platform = m[1]
+	}
+	return &out
+}
+
+// appendUnique appends the values to the list which it does not contain.
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, e := range list {
+			if e == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// withBaseModules returns the configuration with baseInitrdModules added
+// to the modules available in the initrd.
+func (c hardwareConfig) withBaseModules() *hardwareConfig {
+	c.InitrdAvailableModules = appendUnique(append([]string(nil), baseInitrdModules...), c.InitrdAvailableModules...)
+	return &c
+}
+
+// nix returns the contents of hardware-configuration.nix.
+func (c *hardwareConfig) nix() nix.Expr {
+	extraPackages := make(nix.List, len(c.ExtraModulePackages))
+	for i, p := range c.ExtraModulePackages {
+		extraPackages[i] = nix.Raw(p)
+	}
+
+	cfg := nix.AttrSet{
+		nix.A(nix.StringList(c.InitrdAvailableModules), "boot", "initrd", "availableKernelModules"),
+		nix.A(nix.StringList(c.InitrdKernelModules), "boot", "initrd", "kernelModules"),
+		nix.A(nix.StringList(c.KernelModules), "boot", "kernelModules"),
+		nix.A(extraPackages, "boot", "extraModulePackages"),
+		nix.A(mkDefault(nix.Bool(true)), "hardware", "enableRedistributableFirmware"),
+	}
+	for _, vendor := range c.Microcode {
+		cfg = append(cfg, nix.A(mkDefault(nix.Raw("config.hardware.enableRedistributableFirmware")), "hardware", "cpu", vendor, "updateMicrocode"))
+	}
+	if c.Platform != "" {
+		cfg = append(cfg, nix.A(mkDefault(nix.String(c.Platform)), "nixpkgs", "hostPlatform"))
+	}
+	return nix.Func{Args: []string{"config", "lib"}, Ellipsis: true, Body: cfg}
+}
+
+func mkDefault(e nix.Expr) nix.Expr {
+	return nix.Apply{Fn: nix.Raw("lib.mkDefault"), Args: []nix.Expr{e}}
+}
+
+// detectHardwareConfig runs nixos-generate-config against the system
+// mounted at mountBase.
+func detectHardwareConfig(updateChan chan Update, mountBase string) (*hardwareConfig, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("sudo", "nixos-generate-config", "--root", mountBase, "--show-hardware-config")
+	cmd.Stdout = &out
+	if err := execCmd(updateChan, cmd, &out); err != nil {
+		return nil, err
+	}
+	return parseHardwareConfig(out.String()), nil
+}
@@ -0,0 +1,53 @@
+package install
+
+import (
+	"reflect"
+	"testing"
+)
+
+const generatedHwConfig = `# Do not modify this file!  It was generated by 'nixos-generate-config'
+{ config, lib, pkgs, modulesPath, ... }:
+
+{
+  imports =
+    [ (modulesPath + "/installer/scan/not-detected.nix")
+    ];
+
+  boot.initrd.availableKernelModules = [ "xhci_pci" "thunderbolt" "nvme" "usb_storage" "sd_mod" ];
+  boot.initrd.kernelModules = [ ];
+  boot.kernelModules = [ "kvm-intel" "wl" ];
+  boot.extraModulePackages = [ config.boot.kernelPackages.broadcom_sta ];
+
+  fileSystems."/" =
+    { device = "/dev/disk/by-uuid/0f0e2a44-61a4-4a4e-8f9c-2b5b8d3b3f0e";
+      fsType = "ext4";
+    };
+
+  swapDevices = [ ];
+
+  networking.useDHCP = lib.mkDefault true;
+
+  nixpkgs.hostPlatform = lib.mkDefault "x86_64-linux";
+  hardware.cpu.intel.updateMicrocode = lib.mkDefault config.hardware.enableRedistributableFirmware;
+}
+`
+
+func TestParseHardwareConfig(t *testing.T) {
+	got := parseHardwareConfig(generatedHwConfig)
+	want := &hardwareConfig{
+		InitrdAvailableModules: []string{"xhci_pci", "thunderbolt", "nvme", "usb_storage", "sd_mod"},
+		KernelModules:          []string{"kvm-intel", "wl"},
+		ExtraModulePackages:    []string{"config.boot.kernelPackages.broadcom_sta"},
+		Microcode:              []string{"intel"},
+		Platform:               "x86_64-linux",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseHardwareConfig() = %+v, want %+v", got, want)
+	}
+
+	merged := got.withBaseModules()
+	wantModules := append(append([]string(nil), baseInitrdModules...), "thunderbolt", "usb_storage")
+	if !reflect.DeepEqual(merged.InitrdAvailableModules, wantModules) {
+		t.Errorf("withBaseModules() modules = %q, want %q", merged.InitrdAvailableModules, wantModules)
+	}
+}
@@ -1,14 +1,21 @@
 package install
 
-import "fmt"
-import "time"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
 
 // Run represents a running installation process.
 type Run struct {
-	done bool
+	err    error
+	failed int // index of the step which failed
 
 	uiUpdate chan Update
+	finished chan struct{} // closed when the current run has finished
 	config   Settings
+	log      *installLog
 
 	steps []step
 }
@@ -19,44 +26,256 @@ type step interface {
 	Name() string
 }
 
+// weightedStep is implemented by steps which take a notable share of the
+// time of an install, relative to the default weight of 1.
+type weightedStep interface {
+	Weight(*Run) float64
+}
+
+func (r *Run) stepWeight(s step) float64 {
+	if w, ok := s.(weightedStep); ok {
+		return w.Weight(r)
+	}
+	return 1
+}
+
 // Configure prepares an installation.
 func Configure(ch chan Update, config Settings) *Run {
+	var steps []step
+	// Extra packages are checked first, as the offline check would fail
+	// less clearly on a missing package.
+	if len(config.Extra.Packages) > 0 || len(config.Extra.Snippets) > 0 {
+		steps = append(steps, &ExtraCheckStep{})
+	}
+	if config.Offline.Enable {
+		steps = append(steps, &OfflineCheckStep{})
+	}
+	hooks := config.hookPhaseSet()
+	// Steps to run hooks are only added for phases with hooks.
+	for _, s := range []step{
+		&HookStep{Phase: PhasePrePartition},
+		&PartitionStep{},
+		&HookStep{Phase: PhasePostPartition},
+		&ConfigureStep{},
+		&ApplyStep{},
+		&HookStep{Phase: PhasePostConfigure},
+		&InstallStep{},
+		&HookStep{Phase: PhasePostInstall},
+	} {
+		if h, ok := s.(*HookStep); ok && !hooks[h.Phase] {
+			continue
+		}
+		steps = append(steps, s)
+	}
 	return &Run{
 		uiUpdate: ch,
 		config:   config,
-		steps: []step{
-			&PartitionStep{},
-			&ConfigureStep{},
-			&InstallStep{},
-		},
+		steps:    steps,
 	}
 }
 
 // Start commences an installation.
 func (r *Run) Start() error {
-	go r.install()
+	return r.startFrom(0)
+}
+
+// Resume continues an installation to the same disk which was interrupted
+// or failed, starting from the first step which did not complete. The
+// installation is started from the beginning if there is nothing to resume.
+func (r *Run) Resume() error {
+	s, err := loadState()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r.startFrom(0)
+		}
+		return err
+	}
+
+	idx := r.resumeIndex(s)
+	if idx >= len(r.steps) {
+		return errors.New("installation already completed")
+	}
+	return r.startFrom(idx)
+}
+
+// Retry restarts a failed installation from the step which failed, once all
+// updates from the failed run have been delivered.
+func (r *Run) Retry() error {
+	if r.err == nil {
+		return errors.New("installation has not failed")
+	}
+	return r.startFrom(r.failed)
+}
+
+func (r *Run) startFrom(idx int) error {
+	if r.finished != nil {
+		select {
+		case <-r.finished:
+		default:
+			return errors.New("installation is still running")
+		}
+	}
+	if r.log == nil {
+		var err error
+		if r.log, err = openInstallLog(os.TempDir()); err != nil {
+			return fmt.Errorf("creating log: %v", err)
+		}
+	} else if err := r.log.reopen(); err != nil {
+		return fmt.Errorf("reopening log: %v", err)
+	}
+
+	r.err = nil
+	updates := make(chan Update)
+	r.finished = make(chan struct{})
+	go r.forward(idx, updates, r.finished)
+	go r.install(idx, updates)
 	return nil
 }
 
-func (r *Run) install() {
-	for _, step := range r.steps {
-		r.uiUpdate <- Update{Step: step.Stage()}
-		r.uiUpdate <- Update{Msg: step.Name() + "\n", Level: MsgCmd}
-		if err := step.Exec(r.uiUpdate, r); err != nil {
-			r.uiUpdate <- Update{Msg: fmt.Sprintf("\nStep %q failed! %v\n", step.Name(), err), Level: MsgErr}
-			return
+// forward timestamps updates from the steps and passes them on to the UI,
+// computing the overall progress of the install as it goes.
+func (r *Run) forward(startIdx int, updates chan Update, done chan struct{}) {
+	var (
+		start                 = time.Now()
+		totalWeight, finished float64
+		current               float64
+	)
+	for i, s := range r.steps {
+		totalWeight += r.stepWeight(s)
+		if i < startIdx {
+			finished += r.stepWeight(s)
+		}
+	}
+
+	for u := range updates {
+		if u.Time.IsZero() {
+			u.Time = time.Now()
 		}
-		r.uiUpdate <- Update{Msg: "\n", Level: MsgCmd}
+
+		switch u.Kind {
+		case EventStepStarted:
+			for _, s := range r.steps {
+				if s.Name() == u.StepName {
+					current = r.stepWeight(s)
+				}
+			}
+		case EventStepFinished:
+			finished += current
+			current = 0
+		case EventProgress:
+			u.Progress = clampFraction((finished + current*u.StepProgress) / totalWeight)
+			if u.Progress > 0 {
+				elapsed := u.Time.Sub(start)
+				u.ETA = time.Duration(float64(elapsed) * (1 - u.Progress) / u.Progress).Round(time.Second)
+			}
+		}
+		r.log.Write(u)
+		if u.Kind == EventResult && u.Complete {
+			// The log is copied once it records the result, which is the
+			// last update the UI receives.
+			r.persistLog("/mnt")
+		}
+		r.uiUpdate <- u
 	}
-	r.uiUpdate <- Update{Complete: true}
-	r.done = true
+	r.log.Close()
+	close(done)
 }
 
-func (r *Run) Wait() {
-	for {
-		if r.done {
-			break
+// persistLog copies the log into the installed system, logging and passing
+// on the updates from copying it.
+func (r *Run) persistLog(mountBase string) {
+	updates := make(chan Update)
+	go func() {
+		defer close(updates)
+		if err := r.log.persist(updates, mountBase); err != nil {
+			progressWarn(updates, "Failed to copy the install log to the new system: %v\n", err)
+		}
+	}()
+	for u := range updates {
+		if u.Time.IsZero() {
+			u.Time = time.Now()
 		}
-		time.Sleep(250 * time.Millisecond)
+		r.log.Write(u)
+		r.uiUpdate <- u
+	}
+}
+
+func (r *Run) install(startIdx int, updates chan Update) {
+	defer close(updates)
+
+	if startIdx == 0 {
+		if err := os.Remove(statePath()); err != nil && !os.IsNotExist(err) {
+			progressWarn(updates, "Failed to clear install state: %v\n", err)
+		}
+	} else {
+		progressInfo(updates, "Resuming from step %q.\n", r.steps[startIdx].Name())
+	}
+	if r.partitioned(startIdx) {
+		if err := r.prepareResume(updates, "/mnt"); err != nil {
+			r.failed, r.err = startIdx, r.stepError(r.steps[startIdx], fmt.Errorf("preparing to resume: %w", err))
+			updates <- Update{Msg: fmt.Sprintf("\nFailed to resume! %v\n", err), Level: MsgErr}
+			updates <- Update{Kind: EventResult, Err: r.err}
+			return
+		}
+	}
+
+	for i := startIdx; i < len(r.steps); i++ {
+		step := r.steps[i]
+		start := time.Now()
+		updates <- Update{Kind: EventStepStarted, Step: step.Stage(), StepName: step.Name()}
+		updates <- Update{Msg: step.Name() + "\n", Level: MsgCmd}
+		if err := step.Exec(updates, r); err != nil {
+			r.failed, r.err = i, r.stepError(step, err)
+			updates <- Update{Msg: fmt.Sprintf("\nStep %q failed! %v\n", step.Name(), err), Level: MsgErr}
+			if err := r.CollectDiagnostics(r.DiagnosticsPath()); err != nil {
+				progressWarn(updates, "Failed to collect diagnostics: %v\n", err)
+			} else {
+				progressInfo(updates, "Diagnostics written to %s\n", r.DiagnosticsPath())
+			}
+			updates <- Update{Kind: EventResult, Step: step.Stage(), StepName: step.Name(), Err: r.err}
+			return
+		}
+		updates <- Update{Msg: "\n", Level: MsgCmd}
+		updates <- Update{Kind: EventProgress, StepProgress: 1}
+		updates <- Update{Kind: EventStepFinished, Step: step.Stage(), StepName: step.Name(), Duration: time.Since(start)}
+		if err := r.markCompleted(i); err != nil {
+			progressWarn(updates, "Failed to save install state: %v\n", err)
+		}
+	}
+
+	updates <- Update{Kind: EventResult, Complete: true}
+}
+
+func (r *Run) stepError(s step, err error) *StepError {
+	return &StepError{
+		Step:     s.Stage(),
+		StepName: s.Name(),
+		Class:    classifyFailure(err),
+		Err:      err,
+	}
+}
+
+// FailedStep returns the name of the step which failed, if the installation
+// has failed.
+func (r *Run) FailedStep() string {
+	if r.err == nil {
+		return ""
+	}
+	return r.steps[r.failed].Name()
+}
+
+// LogPath returns the path to the log of the installation.
+func (r *Run) LogPath() string {
+	return r.log.path
+}
+
+// Wait blocks until the installation has finished and all updates have been
+// delivered, returning the error which caused the installation to fail,
+// if any. It returns immediately if the installation was never started.
+func (r *Run) Wait() error {
+	if r.finished == nil {
+		return r.err
 	}
+	<-r.finished
+	return r.err
 }
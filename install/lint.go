@@ -0,0 +1,97 @@
+package install
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Diagnostic is a problem with the markers in a file.
+type Diagnostic struct {
+	Path string
+	Line int
+	Msg  string
+
+	// tolerated is set if the Applyer leaves the marked content as it is,
+	// rather than failing.
+	tolerated bool
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d: %s", d.Path, d.Line, d.Msg)
+}
+
+// lintFile returns the problems with the markers in a file, as found when
+// applying them.
+func lintFile(srcPath string) ([]Diagnostic, error) {
+	contents, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	_, diags, err := scanMarkers(srcPath, contents)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", srcPath, err)
+	}
+	return diags, nil
+}
+
+// Lint checks the markers in the files under TwlBasePath without changing
+// them, returning the problems found.
+func (a *Applyer) Lint() ([]Diagnostic, error) {
+	var out []Diagnostic
+	err := a.walk(func(srcPath string, size int64) error {
+		d, err := lintFile(srcPath)
+		out = append(out, d...)
+		return err
+	})
+	return out, err
+}
+
+// PreviewMarkers applies the markers to a copy of the Applyer's tree,
+// using the given settings, and writes a unified diff of the changes to w.
+// The tree itself is not modified.
+func (a *Applyer) PreviewMarkers(config Settings, w io.Writer) error {
+	dir, err := ioutil.TempDir("", "twlinst-preview")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// The diff is between a/ and b/, like git.
+	src, err := filepath.Abs(a.TwlBasePath)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(src, filepath.Join(dir, "a")); err != nil {
+		return err
+	}
+	if out, err := exec.Command("cp", "-a", src, filepath.Join(dir, "b")).CombinedOutput(); err != nil {
+		return fmt.Errorf("copying %s: %s (%v)", src, strings.TrimSpace(string(out)), err)
+	}
+
+	preview := &Applyer{
+		TwlBasePath: filepath.Join(dir, "b"),
+		Run:         &Run{config: config},
+		Include:     a.Include,
+		Exclude:     a.Exclude,
+	}
+	if err := preview.Exec(); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("diff", "-ruN", "a", "b")
+	cmd.Dir = dir
+	cmd.Stdout = w
+	err = cmd.Run()
+	// diff exits with status 1 if there are differences.
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode() == 1 {
+		return nil
+	}
+	return err
+}
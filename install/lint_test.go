@@ -0,0 +1,135 @@
+package install
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tcs := []struct {
+		name     string
+		contents string
+		want     []string
+		// applies is set if the Applyer tolerates the problems.
+		applies bool
+	}{
+		{
+			name: "valid",
+			contents: `{
+  # File-marker: If flake
+  a = 1; # Line-marker: Trim on install
+  # Start-marker: Substitute hostname
+  hostName = "live";
+  # End-marker: Substitute hostname
+  # Start-marker: Unless ssh && hardware=lenovo/*
+  # b = 2;
+  # End-marker: Unless ssh && hardware=lenovo/*
+}
+`,
+		},
+		{
+			name:     "unknown markers",
+			contents: "a # Line-marker: Trim on instal\n# Start-marker: Substitute colour\n# End-marker: Substitute colour\n",
+			want: []string{
+				`1: unknown line marker: "Trim on instal"`,
+				`2: unknown substitution "colour"`,
+			},
+		},
+		{
+			name:     "bad condition",
+			contents: "a # Line-marker: If gpu\nb # Line-marker: Unless (ssh\n",
+			want: []string{
+				`1: marker "If gpu": unknown field "gpu"`,
+				`2: marker "Unless (ssh": missing )`,
+			},
+		},
+		{
+			name:     "file markers",
+			contents: "# File-marker: Uncomment on install\n# File-marker: Trim on install\n",
+			want: []string{
+				`1: unknown file marker: "Uncomment on install"`,
+				`2: more than one file marker, the first is on line 1`,
+			},
+		},
+		{
+			// Sections with unknown markers are left as they are when
+			// applying, so a typo would go unnoticed.
+			name:     "unknown section",
+			contents: "# Start-marker: Trim on instal\nx = 1;\n# End-marker: Trim on instal\n",
+			want:     []string{`1: unknown section marker: "Trim on instal"`},
+			applies:  true,
+		},
+		{
+			name:     "substitution without value",
+			contents: "  \"nixos\" # Line-marker: Substitute username\n",
+			want:     []string{`1: marker "Substitute username": no value to substitute`},
+		},
+		{
+			name:     "unmatched",
+			contents: "# End-marker: Trim on install\n# Start-marker: Trim on install\n# End-marker: If ssh\n# Start-marker: If ssh\n",
+			want: []string{
+				`1: end marker "Trim on install" has no start marker`,
+				`3: end marker "If ssh" does not match start marker "Trim on install" on line 2`,
+				`4: section "If ssh" has no end marker`,
+			},
+		},
+		{
+			name:     "nested",
+			contents: "# Start-marker: If ssh\n# Start-marker: Trim on install\nx # Line-marker: Trim on install\n# End-marker: Trim on install\n# End-marker: If ssh\n",
+			want: []string{
+				`2: section "Trim on install" is nested in section "If ssh" from line 1`,
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, _ := ioutil.TempDir("", "")
+			defer os.RemoveAll(dir)
+			ioutil.WriteFile(path.Join(dir, "default.nix"), []byte(tc.contents), 0644)
+
+			a := &Applyer{TwlBasePath: dir}
+			diags, err := a.Lint()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, d := range diags {
+				got = append(got, strings.TrimPrefix(d.String(), path.Join(dir, "default.nix")+":"))
+			}
+			if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+				t.Errorf("got diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+
+			// Applying fails when there are problems it does not tolerate.
+			wantErr := len(tc.want) > 0 && !tc.applies
+			err = (&Applyer{TwlBasePath: dir, Run: &Run{}}).Exec()
+			if (err != nil) != wantErr {
+				t.Errorf("Exec() = %v, want error %v", err, wantErr)
+			}
+		})
+	}
+}
+
+func TestPreviewMarkers(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	contents := "{\n  a = 1; # Line-marker: Trim on install\n  b = 2;\n}\n"
+	ioutil.WriteFile(path.Join(dir, "default.nix"), []byte(contents), 0644)
+
+	var out bytes.Buffer
+	a := &Applyer{TwlBasePath: dir}
+	if err := a.PreviewMarkers(Settings{}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "-  a = 1; # Line-marker: Trim on install\n") {
+		t.Errorf("diff missing removed line:\n%s", out.String())
+	}
+	if b, _ := ioutil.ReadFile(path.Join(dir, "default.nix")); string(b) != contents {
+		t.Errorf("source was modified: %q", b)
+	}
+}
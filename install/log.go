@@ -0,0 +1,123 @@
+package install
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logTimeFmt is the timestamp format used for each line in the log.
+const logTimeFmt = "2006-01-02T15:04:05.000Z07:00"
+
+// installLog records a timestamped transcript of an install to a file.
+type installLog struct {
+	path string
+	f    *os.File
+
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func openInstallLog(dir string) (*installLog, error) {
+	path := filepath.Join(dir, fmt.Sprintf("twlinst-%s.log", time.Now().Format("20060102-150405")))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &installLog{path: path, f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (l *installLog) writeLines(ts time.Time, tag, msg string) {
+	for _, line := range strings.Split(strings.TrimRight(msg, "\n"), "\n") {
+		fmt.Fprintf(l.w, "%s [%s] %s\n", ts.Format(logTimeFmt), tag, strings.TrimRight(line, "\r"))
+	}
+}
+
+// Write records the update in the log.
+func (l *installLog) Write(u Update) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch u.Kind {
+	case EventLog, EventWarning:
+		if strings.TrimSpace(u.Msg) == "" {
+			return
+		}
+		l.writeLines(u.Time, u.Level.String(), u.Msg)
+	case EventStepStarted:
+		l.writeLines(u.Time, "step", fmt.Sprintf("Started %q (%s)", u.StepName, u.Step))
+	case EventStepFinished:
+		l.writeLines(u.Time, "step", fmt.Sprintf("Finished %q after %s", u.StepName, u.Duration))
+	case EventCmdStarted:
+		l.writeLines(u.Time, "cmd", fmt.Sprintf("$ %s", strings.Join(u.Argv, " ")))
+	case EventCmdExited:
+		l.writeLines(u.Time, "cmd", fmt.Sprintf("Exited %d after %s: %s", u.ExitCode, u.Duration, strings.Join(u.Argv, " ")))
+		if u.Output != "" {
+			l.writeLines(u.Time, "output", u.Output)
+		}
+	case EventResult:
+		if u.Err != nil {
+			l.writeLines(u.Time, "result", "Install failed: "+u.Err.Error())
+		} else {
+			l.writeLines(u.Time, "result", "Install complete")
+		}
+	}
+	// Flush after every update, so the log is complete should we crash.
+	l.w.Flush()
+}
+
+func (l *installLog) flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Flush()
+}
+
+// reopen opens the log file to append to it, if it was closed when a
+// previous run finished.
+func (l *installLog) reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		return nil
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	l.f, l.w = f, bufio.NewWriter(f)
+	return nil
+}
+
+// Close flushes and closes the log file.
+func (l *installLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.w.Flush(); err != nil {
+		l.f.Close()
+		l.f = nil
+		return err
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// persist copies the log into the installed system.
+func (l *installLog) persist(updateChan chan Update, mountBase string) error {
+	if err := l.flush(); err != nil {
+		return err
+	}
+	logDir := filepath.Join(mountBase, "var", "log", "twlinst")
+	if out, err := runCmdOutput(updateChan, exec.Command("sudo", "mkdir", "-p", logDir)); err != nil {
+		return fmt.Errorf("mkdir %s: %s (%v)", logDir, strings.TrimSpace(string(out)), err)
+	}
+	if out, err := runCmdOutput(updateChan, exec.Command("sudo", "cp", l.path, filepath.Join(logDir, filepath.Base(l.path)))); err != nil {
+		return fmt.Errorf("copying log: %s (%v)", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package install
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/twitchylinux/twlinst/nix"
+)
+
+// filesystemsNix returns the contents of filesystems.nix, given the UUIDs
+// of the boot filesystem, the LUKS container and the root filesystem.
+func filesystemsNix(bootUUID, luksUUID, ext4UUID string) nix.Expr {
+	return nix.Func{
+		Args:     []string{"config", "pkgs", "boot", "lib"},
+		Ellipsis: true,
+		Body: nix.AttrSet{
+			nix.A(nix.AttrSet{
+				nix.A(nix.AttrSet{
+					nix.A(nix.String("/dev/disk/by-uuid/"+luksUUID), "device"),
+				}, "cryptroot"),
+			}, "boot", "initrd", "luks", "devices"),
+
+			nix.A(nix.AttrSet{
+				nix.A(nix.AttrSet{
+					nix.A(nix.String("/dev/disk/by-uuid/"+ext4UUID), "device"),
+					nix.A(nix.String("ext4"), "fsType"),
+				}, "/"),
+				nix.A(nix.AttrSet{
+					nix.A(nix.String("/dev/disk/by-uuid/"+bootUUID), "device"),
+					nix.A(nix.String("vfat"), "fsType"),
+				}, "/boot"),
+			}, "fileSystems"),
+		},
+	}
+}
+
+// configurationNix returns the contents of configuration.nix. The users
+// must have their PasswordHash populated.
+func configurationNix(c *Settings, users []User) nix.Expr {
+	imports := nix.List{
+		sourcePath(c, twlBaseInput, ""),
+		nix.Path("./filesystems.nix"),
+		nix.Path("./hardware-configuration.nix"),
+	}
+	for _, imp := range c.NixosHardwareImports {
+		imports = append(imports, sourcePath(c, hardwareInput, "/"+imp))
+	}
+	imports = append(imports, c.Extra.imports()...)
+
+	cfg := nix.AttrSet{nix.A(imports, "imports")}
+	for _, u := range users {
+		cfg = append(cfg, nix.A(userNix(&u), "users", "users", u.Name))
+	}
+	programs := map[string]bool{}
+	for _, u := range users {
+		if shellPrograms[u.Shell] && !programs[u.Shell] {
+			programs[u.Shell] = true
+			cfg = append(cfg, nix.A(nix.Bool(true), "programs", u.Shell, "enable"))
+		}
+	}
+
+	if len(c.Extra.Packages) > 0 {
+		cfg = append(cfg, nix.A(c.Extra.packagesNix(), "environment", "systemPackages"))
+	}
+
+	if c.SSH.Enable {
+		cfg = append(cfg, nix.A(sshNix(&c.SSH), "services", "openssh"))
+	}
+
+	if c.Locale != "" {
+		cfg = append(cfg, nix.A(nix.String(c.Locale), "i18n", "defaultLocale"))
+	}
+	if c.Keyboard.Layout != "" {
+		xkb := nix.AttrSet{nix.A(nix.String(c.Keyboard.Layout), "layout")}
+		if c.Keyboard.Variant != "" {
+			xkb = append(xkb, nix.A(nix.String(c.Keyboard.Variant), "variant"))
+		}
+		cfg = append(cfg,
+			nix.A(xkb, "services", "xserver", "xkb"),
+			nix.Attr{
+				Key:     []string{"console", "useXkbConfig"},
+				Value:   nix.Bool(true),
+				Comment: "Use the keyboard layout on the console, including the disk unlock\nprompt in the initrd.",
+			},
+			nix.A(nix.Bool(true), "console", "earlySetup"),
+		)
+	}
+
+	cfg = append(cfg,
+		nix.A(nix.String(c.Timezone), "time", "timeZone"),
+		nix.A(nix.String(c.Hostname), "networking", "hostName"),
+	)
+	if c.Autologin && len(users) > 0 {
+		cfg = append(cfg, nix.Attr{
+			Key:     []string{"services", "getty", "autologinUser"},
+			Value:   nix.String(users[0].Name),
+			Comment: "Automatically login on startup.",
+		})
+	}
+
+	// Each user gets the default configuration from the skeleton. The
+	// primary user keeps the name used before multiple users were supported.
+	for i, u := range users {
+		name := "etc"
+		if i > 0 {
+			name = "user-skel-" + u.Name
+		}
+		cfg = append(cfg, nix.A(nix.Apply{
+			Fn: nix.Apply{Fn: nix.Raw("import"), Args: []nix.Expr{sourcePath(c, twlBaseInput, "/user-skel/default-user-config.nix")}},
+			Args: []nix.Expr{nix.AttrSet{
+				nix.A(nix.Raw("lib"), "lib"),
+				nix.A(nix.String(u.Name), "username"),
+				nix.A(nix.Bool(c.Autologin && i == 0), "autologin"),
+			}},
+		}, "system", "activationScripts", name))
+	}
+
+	args := []string{"lib", "pkgs"}
+	if c.Flake {
+		// The local sources are passed in by flake.nix.
+		args = append(args, twlBaseInput, hardwareInput)
+		cfg = append(cfg, nix.A(nix.StringList([]string{"nix-command", "flakes"}), "nix", "settings", "experimental-features"))
+	}
+	return nix.Func{Args: args, Ellipsis: true, Body: cfg}
+}
+
+func userNix(u *User) nix.AttrSet {
+	out := nix.AttrSet{nix.A(nix.Bool(true), "isNormalUser")}
+	if u.FullName != "" {
+		out = append(out, nix.A(nix.String(u.FullName), "description"))
+	}
+	out = append(out,
+		nix.A(nix.StringList(u.AllGroups()), "extraGroups"),
+		nix.A(nix.String(u.PasswordHash), "hashedPassword"),
+	)
+	if keys := u.AuthorizedKeys.Keys; len(keys) > 0 {
+		out = append(out, nix.A(nix.StringList(keys), "openssh", "authorizedKeys", "keys"))
+	}
+	switch {
+	case u.Shell == "":
+	case strings.HasPrefix(u.Shell, "/"):
+		out = append(out, nix.A(nix.String(u.Shell), "shell"))
+	default:
+		out = append(out, nix.A(nix.Select{Expr: nix.Raw("pkgs"), Path: []string{u.Shell}}, "shell"))
+	}
+	return out
+}
+
+func sshNix(s *SSHSettings) nix.AttrSet {
+	port := s.Port
+	if port == 0 {
+		port = defaultSSHPort
+	}
+	return nix.AttrSet{
+		nix.A(nix.Bool(true), "enable"),
+		nix.A(nix.List{nix.Int(port)}, "ports"),
+		nix.A(nix.Bool(s.PasswordAuth), "settings", "PasswordAuthentication"),
+		nix.A(nix.Bool(s.PasswordAuth), "settings", "KbdInteractiveAuthentication"),
+	}
+}
+
+// writeNixFile writes the expression to path, preceded by header.
+func writeNixFile(path, header string, e nix.Expr) error {
+	return ioutil.WriteFile(path, append([]byte(header), nix.Format(e)...), 0644)
+}
@@ -0,0 +1,147 @@
+package install
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/twitchylinux/twlinst/nix"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *updateGolden {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("output differs from %s:\n--- got\n%s\n--- want\n%s", path, got, want)
+	}
+}
+
+// testUsers returns the users of the settings, with a fixed password hash.
+func testUsers(c *Settings) []User {
+	users := c.AllUsers()
+	for i := range users {
+		if users[i].PasswordHash == "" {
+			users[i].PasswordHash = "$6$salt$hash"
+		}
+	}
+	return users
+}
+
+func testConfigurationNix(c *Settings) nix.Expr {
+	return configurationNix(c, testUsers(c))
+}
+
+func TestNixConfigGolden(t *testing.T) {
+	tcs := []struct {
+		name string
+		expr nix.Expr
+	}{
+		{"filesystems.nix", filesystemsNix("1234-ABCD", "luks-uuid", "ext4-uuid")},
+		{
+			"configuration.nix",
+			testConfigurationNix(&Settings{
+				Username: "alice",
+				Hostname: "twl",
+				Timezone: "Europe/London",
+			}),
+		},
+		{
+			"configuration-autologin.nix",
+			testConfigurationNix(&Settings{
+				Username:             "bob",
+				Hostname:             "laptop",
+				Timezone:             "America/New_York",
+				Autologin:            true,
+				NixosHardwareImports: HardwareImports{"lenovo/thinkpad/x230", "common/gpu/nvidia"},
+			}),
+		},
+		{
+			// Values which would break out of, or interpolate into, a string.
+			"configuration-escaping.nix",
+			testConfigurationNix(&Settings{
+				Username:             "eve\"; evil = true; x = \"",
+				Hostname:             "${builtins.exec \"rm\"}",
+				Timezone:             "Etc\\UTC",
+				NixosHardwareImports: HardwareImports{"weird profile"},
+			}),
+		},
+		{
+			"configuration-users.nix",
+			testConfigurationNix(&Settings{
+				Username:  "alice",
+				Hostname:  "family",
+				Timezone:  "Europe/Berlin",
+				Autologin: true,
+				Users: []User{
+					{Name: "bob", FullName: "Bob \"Bobby\" Smith", PasswordHash: "$6$other$hash", Shell: "zsh"},
+					{Name: "carol", Admin: true, Groups: []string{"docker", "video"}, Shell: "/run/current-system/sw/bin/bash"},
+					{Name: "dave", Shell: "zsh"},
+				},
+			}),
+		},
+		{
+			"configuration-ssh.nix",
+			testConfigurationNix(&Settings{
+				Username:       "alice",
+				Hostname:       "server",
+				Timezone:       "UTC",
+				AuthorizedKeys: AuthorizedKeys{Keys: []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIG4rT3vTt99Ox5kndS4HmgTrKBT8SKzhK4rhGkEVGlCI alice@laptop"}},
+				SSH:            SSHSettings{Enable: true, Port: 2222},
+			}),
+		},
+		{
+			"configuration-locale.nix",
+			testConfigurationNix(&Settings{
+				Username: "hans",
+				Hostname: "rechner",
+				Timezone: "Europe/Berlin",
+				Locale:   "de_DE.UTF-8",
+				Keyboard: KeyboardSettings{Layout: "de", Variant: "nodeadkeys"},
+			}),
+		},
+		{
+			"configuration-flake.nix",
+			testConfigurationNix(&Settings{
+				Username:             "alice",
+				Hostname:             "twl",
+				Timezone:             "Europe/London",
+				Flake:                true,
+				NixosHardwareImports: HardwareImports{"lenovo/thinkpad/x230"},
+			}),
+		},
+		{
+			"configuration-extra.nix",
+			testConfigurationNix(&Settings{
+				Username: "alice",
+				Hostname: "twl",
+				Timezone: "Europe/London",
+				Extra: ExtraConfig{
+					Packages: []string{"git", "python3Packages.requests", "jetbrains.idea-community"},
+					Modules:  []string{"/media/team/vpn.nix"},
+					Snippets: []string{"{ services.printing.enable = true; }"},
+				},
+			}),
+		},
+		{"flake.nix", flakeNix(&Settings{Hostname: "twl"}, "/nix/store/00000000000000000000000000000000-nixos-24.05/nixos")},
+		{"hardware-configuration.nix", parseHardwareConfig(generatedHwConfig).withBaseModules().nix()},
+		{"hardware-configuration-empty.nix", (&hardwareConfig{}).withBaseModules().nix()},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			checkGolden(t, tc.name, nix.Format(tc.expr))
+		})
+	}
+}
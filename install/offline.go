@@ -0,0 +1,227 @@
+package install
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// OfflineSettings configures installing without network access.
+type OfflineSettings struct {
+	Enable bool `json:"enable"`
+	// Cache is the path to a local binary cache, as created by
+	// nix copy --to file://<path>. Without one, only the store of the live
+	// system is used.
+	Cache string `json:"cache"`
+}
+
+// Validate returns an error if the local binary cache is unusable.
+func (o *OfflineSettings) Validate() error {
+	if !o.Enable || o.Cache == "" {
+		return nil
+	}
+	if !filepath.IsAbs(o.Cache) {
+		return fmt.Errorf("binary cache path %q is not absolute", o.Cache)
+	}
+	if _, err := os.Stat(filepath.Join(o.Cache, "nix-cache-info")); err != nil {
+		return fmt.Errorf("%s is not a binary cache: %v", o.Cache, err)
+	}
+	return nil
+}
+
+// substituters returns the value of the substituters option for an
+// offline install. nixos-install separately adds the live system's store.
+func (o *OfflineSettings) substituters() string {
+	if o.Cache == "" {
+		return ""
+	}
+	// The cache is trusted as it is on media we were booted alongside, and
+	// is unlikely to be signed.
+	return "file://" + o.Cache + "?trusted=1"
+}
+
+// nixOptions returns the options to pass to Nix commands so they only
+// substitute from local sources.
+func (o *OfflineSettings) nixOptions() []string {
+	if !o.Enable {
+		return nil
+	}
+	return []string{"--option", "substituters", o.substituters()}
+}
+
+// dryRun is the plan reported by nix-build --dry-run.
+type dryRun struct {
+	// Build and Fetch are store paths of derivations to build and of
+	// outputs to fetch from a substituter.
+	Build, Fetch []string
+}
+
+var dryRunHeaderExp = regexp.MustCompile(`^(these|this) .*will be (built|fetched)`)
+
+// parseDryRun parses the output of nix-build --dry-run.
+func parseDryRun(out string) dryRun {
+	var (
+		d    dryRun
+		list *[]string
+	)
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		if m := dryRunHeaderExp.FindStringSubmatch(line); m != nil {
+			if m[2] == "built" {
+				list = &d.Build
+			} else {
+				list = &d.Fetch
+			}
+			continue
+		}
+		if trimmed := strings.TrimSpace(line); list != nil && strings.HasPrefix(line, " ") && strings.HasPrefix(trimmed, "/nix/store/") {
+			*list = append(*list, trimmed)
+			continue
+		}
+		list = nil
+	}
+	return d
+}
+
+// drvOutputExp matches the first output of a derivation in ATerm format:
+// its name, path, hash algorithm and hash. The hash is only set for
+// fixed-output derivations, which download their output.
+var drvOutputExp = regexp.MustCompile(`^Derive\(\[\("[^"]*","[^"]*","([^"]*)","([^"]*)"\)`)
+
+// isFixedOutput returns true if the derivation fetches its output, and so
+// needs the network to build.
+func isFixedOutput(drv []byte) bool {
+	m := drvOutputExp.FindSubmatch(drv)
+	return m != nil && len(m[1]) > 0 && len(m[2]) > 0
+}
+
+// missingPaths returns the derivations in the plan which download their
+// outputs. Other derivations can be built from what is available.
+func (d *dryRun) missingPaths() ([]string, error) {
+	var out []string
+	for _, drv := range d.Build {
+		contents, err := ioutil.ReadFile(drv)
+		if err != nil {
+			return nil, err
+		}
+		if isFixedOutput(contents) {
+			out = append(out, drv)
+		}
+	}
+	return out, nil
+}
+
+// maxReportedPaths limits how many missing paths are listed in an error.
+const maxReportedPaths = 20
+
+// OfflineCheckStep verifies the system can be built without network
+// access before the disk is touched, by evaluating the configuration the
+// install would generate against the local sources.
+type OfflineCheckStep struct{}
+
+func (s *OfflineCheckStep) Exec(updateChan chan Update, run *Run) error {
+	dir, err := ioutil.TempDir("", "twlinst-offline-check")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	progressInfo(updateChan, "  Preparing a trial configuration.\n")
+	config, err := s.stageConfig(updateChan, run, dir)
+	if err != nil {
+		return fmt.Errorf("preparing configuration: %w", err)
+	}
+
+	progressInfo(updateChan, "  Checking the system closure is available locally.\n")
+	args := append([]string{"<nixpkgs/nixos>", "-A", "system", "--dry-run", "-I", "nixos-config=" + config}, run.config.Offline.nixOptions()...)
+	out, err := runCmdOutput(updateChan, exec.Command("nix-build", args...))
+	if err != nil {
+		return fmt.Errorf("evaluating configuration: %w", err)
+	}
+
+	plan := parseDryRun(string(out))
+	missing, err := plan.missingPaths()
+	if err != nil {
+		return fmt.Errorf("reading derivations: %w", err)
+	}
+	progressInfo(updateChan, "  %d paths will be copied from the binary cache and %d built locally.\n", len(plan.Fetch), len(plan.Build)-len(missing))
+	if len(missing) == 0 {
+		return nil
+	}
+
+	for i, p := range missing {
+		if i == maxReportedPaths {
+			progressWarn(updateChan, "    ... and %d more\n", len(missing)-maxReportedPaths)
+			break
+		}
+		progressWarn(updateChan, "    %s\n", p)
+	}
+	return fmt.Errorf("%d paths are not available offline and must be downloaded", len(missing))
+}
+
+// stageConfig writes the configuration the install would use under dir,
+// returning the path to configuration.nix. Values which do not change the
+// closure, such as disk UUIDs and password hashes, are placeholders.
+func (s *OfflineCheckStep) stageConfig(updateChan chan Update, run *Run, dir string) (string, error) {
+	etc := filepath.Join(dir, "etc")
+	if err := os.MkdirAll(etc, 0755); err != nil {
+		return "", err
+	}
+	// Only /etc/nixos has files written into it, so the rest is linked.
+	for _, src := range []string{twlBaseSource, hardwareSource} {
+		if err := os.Symlink(src, filepath.Join(etc, filepath.Base(src))); err != nil {
+			return "", err
+		}
+	}
+	if _, err := runCmdOutput(updateChan, exec.Command("cp", "-ar", "/etc/nixos", etc)); err != nil {
+		return "", err
+	}
+
+	const placeholderUUID = "00000000-0000-0000-0000-000000000000"
+	nixosDir := filepath.Join(etc, "nixos")
+	if err := writeNixFile(filepath.Join(nixosDir, "filesystems.nix"), "", filesystemsNix(placeholderUUID, placeholderUUID, placeholderUUID)); err != nil {
+		return "", err
+	}
+
+	// Detection on the live system finds the same modules as the install.
+	hw, err := detectHardwareConfig(updateChan, "/")
+	if err != nil {
+		progressWarn(updateChan, "  Hardware detection failed, using defaults: %v\n", err)
+		hw = &hardwareConfig{}
+	}
+	if err := writeNixFile(filepath.Join(nixosDir, "hardware-configuration.nix"), hwConfigHeader, hw.withBaseModules().nix()); err != nil {
+		return "", err
+	}
+
+	// A flake would build the same closure from the same sources, so the
+	// configuration is checked without one.
+	config := run.config
+	config.Flake = false
+	if err := writeExtraFiles(updateChan, &config.Extra, nixosDir); err != nil {
+		return "", err
+	}
+	users := config.AllUsers()
+	for i := range users {
+		users[i].PasswordHash = "!"
+		users[i].AuthorizedKeys = AuthorizedKeys{}
+	}
+	path := filepath.Join(nixosDir, "configuration.nix")
+	if err := writeNixFile(path, "", configurationNix(&config, users)); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (s *OfflineCheckStep) Name() string {
+	return "Check offline availability"
+}
+
+func (s *OfflineCheckStep) Stage() string {
+	return "check"
+}
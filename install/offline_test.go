@@ -0,0 +1,116 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const dryRunOutput = `these 3 derivations will be built:
+  /nix/store/0kq1lbs5b4nk7pzkjhzzckpf5k7k6qlp-etc.drv
+  /nix/store/5h2yn2s1y0b8qv7r4vjp4wq0k2l8hx3d-source.drv
+  /nix/store/xj8d0mcl6yrq9l9k3g1q6cfrpx0ajmmv-nixos-system-laptop-23.05.drv
+these 2 paths will be fetched (12.50 MiB download, 50.01 MiB unpacked):
+  /nix/store/9vq4aj5rpsf8hzc2ykgrpr0bxl8cb3ma-firefox-115.0
+  /nix/store/ac0fxdjlbyhfw4xzzvp7m63j43l6k2ds-gnome-shell-44.2
+`
+
+func TestParseDryRun(t *testing.T) {
+	want := dryRun{
+		Build: []string{
+			"/nix/store/0kq1lbs5b4nk7pzkjhzzckpf5k7k6qlp-etc.drv",
+			"/nix/store/5h2yn2s1y0b8qv7r4vjp4wq0k2l8hx3d-source.drv",
+			"/nix/store/xj8d0mcl6yrq9l9k3g1q6cfrpx0ajmmv-nixos-system-laptop-23.05.drv",
+		},
+		Fetch: []string{
+			"/nix/store/9vq4aj5rpsf8hzc2ykgrpr0bxl8cb3ma-firefox-115.0",
+			"/nix/store/ac0fxdjlbyhfw4xzzvp7m63j43l6k2ds-gnome-shell-44.2",
+		},
+	}
+	if got := parseDryRun(dryRunOutput); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDryRun() = %+v, want %+v", got, want)
+	}
+
+	single := "this derivation will be built:\n  /nix/store/0kq1lbs5b4nk7pzkjhzzckpf5k7k6qlp-etc.drv\n"
+	if got := parseDryRun(single); len(got.Build) != 1 || len(got.Fetch) != 0 {
+		t.Errorf("parseDryRun(%q) = %+v", single, got)
+	}
+	if got := parseDryRun(""); len(got.Build)+len(got.Fetch) != 0 {
+		t.Errorf("parseDryRun(\"\") = %+v, want nothing", got)
+	}
+}
+
+func TestMissingPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twlinst-drv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	drvs := map[string]string{
+		"etc.drv":    `Derive([("out","/nix/store/0kq1lbs5b4nk7pzkjhzzckpf5k7k6qlp-etc","","")],[],[],"x86_64-linux","/bin/sh",[],[])`,
+		"source.drv": `Derive([("out","/nix/store/5h2yn2s1y0b8qv7r4vjp4wq0k2l8hx3d-source","r:sha256","1b6c3a0ba5f3c1a0c8f1e4a1a5d3f9f0e2b5c1d4a7f8e9b0c3d6e2f1a4b7c8d9")],[],[],"x86_64-linux","builtin:fetchurl",[],[])`,
+	}
+	plan := dryRun{}
+	for name, contents := range drvs {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		plan.Build = append(plan.Build, path)
+	}
+
+	missing, err := plan.missingPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "source.drv")}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("missingPaths() = %v, want %v", missing, want)
+	}
+
+	plan.Build = append(plan.Build, filepath.Join(dir, "gone.drv"))
+	if _, err := plan.missingPaths(); err == nil {
+		t.Error("missingPaths() with an unreadable derivation succeeded")
+	}
+}
+
+func TestOfflineSettings(t *testing.T) {
+	cache, err := ioutil.TempDir("", "twlinst-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache)
+	if err := ioutil.WriteFile(filepath.Join(cache, "nix-cache-info"), []byte("StoreDir: /nix/store\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tcs := []struct {
+		name     string
+		settings OfflineSettings
+		wantErr  bool
+		wantOpts []string
+	}{
+		{"online", OfflineSettings{Cache: "relative"}, false, nil},
+		{"store only", OfflineSettings{Enable: true}, false, []string{"--option", "substituters", ""}},
+		{"cache", OfflineSettings{Enable: true, Cache: cache}, false, []string{"--option", "substituters", "file://" + cache + "?trusted=1"}},
+		{"relative cache", OfflineSettings{Enable: true, Cache: "cache"}, true, nil},
+		{"not a cache", OfflineSettings{Enable: true, Cache: os.TempDir()}, true, nil},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.settings.Validate()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Validate() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := tc.settings.nixOptions(); !reflect.DeepEqual(got, tc.wantOpts) {
+				t.Errorf("nixOptions() = %q, want %q", got, tc.wantOpts)
+			}
+		})
+	}
+}
@@ -1,9 +1,16 @@
 package install
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type MsgLevel uint8
@@ -16,16 +23,165 @@ const (
 	MsgCmd
 )
 
+func (l MsgLevel) String() string {
+	switch l {
+	case MsgInfo:
+		return "info"
+	case MsgWarn:
+		return "warn"
+	case MsgErr:
+		return "error"
+	case MsgCmd:
+		return "cmd"
+	}
+	return fmt.Sprintf("MsgLevel(%d)", uint8(l))
+}
+
+// EventKind describes what an Update represents.
+type EventKind uint8
+
+// Valid EventKind values.
+const (
+	EventLog EventKind = iota
+	EventStepStarted
+	EventStepFinished
+	EventCmdStarted
+	EventCmdExited
+	EventProgress
+	EventWarning
+	EventResult
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventLog:
+		return "log"
+	case EventStepStarted:
+		return "step_started"
+	case EventStepFinished:
+		return "step_finished"
+	case EventCmdStarted:
+		return "cmd_started"
+	case EventCmdExited:
+		return "cmd_exited"
+	case EventProgress:
+		return "progress"
+	case EventWarning:
+		return "warning"
+	case EventResult:
+		return "result"
+	}
+	return fmt.Sprintf("EventKind(%d)", uint8(k))
+}
+
 // Update represents an update for the UI that signals a log message
 // or some kind of progress.
 type Update struct {
-	Step string
+	Kind EventKind
+	Time time.Time
+
+	// Step is the stage of the step, and StepName its human-readable name.
+	Step     string
+	StepName string
 
 	Msg   string
 	Level MsgLevel
 
+	// Argv and ExitCode describe a command, for EventCmdStarted and
+	// EventCmdExited events.
+	Argv     []string
+	ExitCode int
+	// Duration is set on EventStepFinished and EventCmdExited events.
+	Duration time.Duration
+	// Output is the raw output of a command which was captured rather
+	// than streamed, set on EventCmdExited events.
+	Output string
+
+	// Progress is the completed fraction of the overall install, and
+	// StepProgress that of the current step, both between 0 and 1. ETA
+	// estimates the time remaining for the install, if known.
+	Progress     float64
+	StepProgress float64
+	ETA          time.Duration
+
 	TrimLastLine bool
-	Complete bool
+	Complete     bool
+	Err          error
+}
+
+type jsonUpdate struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Step       string    `json:"step,omitempty"`
+	StepName   string    `json:"step_name,omitempty"`
+	Msg        string    `json:"msg,omitempty"`
+	Level      string    `json:"level,omitempty"`
+	Argv       []string  `json:"argv,omitempty"`
+	ExitCode   *int      `json:"exit_code,omitempty"`
+	DurationMS *int64    `json:"duration_ms,omitempty"`
+	Output     string    `json:"output,omitempty"`
+	Percent    *float64  `json:"percent,omitempty"`
+	StepPct    *float64  `json:"step_percent,omitempty"`
+	ETASecs    *int64    `json:"eta_s,omitempty"`
+	Success    *bool     `json:"success,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Failure    *jsonFail `json:"failure,omitempty"`
+}
+
+type jsonFail struct {
+	Class       string   `json:"class"`
+	Explanation string   `json:"explanation"`
+	Argv        []string `json:"argv,omitempty"`
+	ExitCode    *int     `json:"exit_code,omitempty"`
+	StderrTail  string   `json:"stderr_tail,omitempty"`
+}
+
+// MarshalJSON encodes the update as a flat object, including only the
+// fields relevant to its kind.
+func (u Update) MarshalJSON() ([]byte, error) {
+	out := jsonUpdate{
+		Type:     u.Kind.String(),
+		Time:     u.Time,
+		Step:     u.Step,
+		StepName: u.StepName,
+		Msg:      u.Msg,
+		Argv:     u.Argv,
+		Output:   u.Output,
+	}
+
+	switch u.Kind {
+	case EventLog, EventWarning:
+		out.Level = u.Level.String()
+	case EventStepFinished:
+		d := u.Duration.Milliseconds()
+		out.DurationMS = &d
+	case EventCmdExited:
+		d, c := u.Duration.Milliseconds(), u.ExitCode
+		out.DurationMS, out.ExitCode = &d, &c
+	case EventProgress:
+		p, sp := u.Progress*100, u.StepProgress*100
+		out.Percent, out.StepPct = &p, &sp
+		if u.ETA > 0 {
+			eta := int64(u.ETA.Seconds())
+			out.ETASecs = &eta
+		}
+	case EventResult:
+		s := u.Complete
+		out.Success = &s
+	}
+	if u.Err != nil {
+		out.Error = u.Err.Error()
+	}
+	var se *StepError
+	if errors.As(u.Err, &se) {
+		out.Failure = &jsonFail{Class: se.Class.String(), Explanation: se.Explain()}
+		if ce := se.Command(); ce != nil {
+			c := ce.ExitCode
+			out.Failure.Argv, out.Failure.ExitCode, out.Failure.StderrTail = ce.Argv, &c, ce.StderrTail
+		}
+	}
+
+	return json.Marshal(out)
 }
 
 func progressInfo(updateChan chan Update, fmtStr string, args ...interface{}) {
@@ -34,20 +190,46 @@ func progressInfo(updateChan chan Update, fmtStr string, args ...interface{}) {
 	}
 }
 
+func progressWarn(updateChan chan Update, fmtStr string, args ...interface{}) {
+	updateChan <- Update{
+		Kind:  EventWarning,
+		Msg:   fmt.Sprintf("  "+fmtStr, args...),
+		Level: MsgWarn,
+	}
+}
+
 type cmdInteractiveWriter struct {
 	updateChan chan Update
 	logPrefix  string
 	IsErr      bool
 	IsProgress bool
+	// Quiet suppresses logging of output lines, which are still passed
+	// to Progress.
+	Quiet    bool
+	Progress progressParser
 }
 
 func (c *cmdInteractiveWriter) Write(in []byte) (int, error) {
 	for _, line := range strings.Split(string(in), "\n") {
+		// Progress output redraws the line using carriage returns, we only
+		// care about the most recent.
+		if idx := strings.LastIndex(strings.TrimRight(line, "\r"), "\r"); idx >= 0 {
+			line = line[idx+1:]
+		}
 		line = strings.Trim(line, " \r\n")
 		if len(line) < 2 {
 			continue
 		}
 
+		if c.Progress != nil {
+			if frac, ok := c.Progress.Parse(line); ok {
+				c.updateChan <- Update{Kind: EventProgress, StepProgress: frac}
+			}
+		}
+		if c.Quiet {
+			continue
+		}
+
 		out := Update{
 			Msg:          fmt.Sprintf("  %s %s\n", c.logPrefix, line),
 			TrimLastLine: c.IsProgress,
@@ -60,6 +242,66 @@ func (c *cmdInteractiveWriter) Write(in []byte) (int, error) {
 	return len(in), nil
 }
 
+// runCmd runs the command to completion, reporting its invocation and
+// exit status as events.
+func runCmd(updateChan chan Update, cmd *exec.Cmd) error {
+	return execCmd(updateChan, cmd, nil)
+}
+
+// runCmdOutput is like runCmd, but returns the combined stdout & stderr
+// of the command, which is also recorded in the exit event.
+func runCmdOutput(updateChan chan Update, cmd *exec.Cmd) ([]byte, error) {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := execCmd(updateChan, cmd, &out)
+	return out.Bytes(), err
+}
+
+func execCmd(updateChan chan Update, cmd *exec.Cmd, output *bytes.Buffer) error {
+	tail := &tailWriter{max: stderrTailLines}
+	switch {
+	case cmd.Stderr == nil:
+		cmd.Stderr = tail
+	case cmd.Stderr == cmd.Stdout:
+		// Keep a single writer, so exec serializes writes to it.
+		w := io.MultiWriter(cmd.Stderr, tail)
+		cmd.Stdout, cmd.Stderr = w, w
+	default:
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, tail)
+	}
+
+	updateChan <- Update{Kind: EventCmdStarted, Argv: cmd.Args}
+	start := time.Now()
+
+	err := cmd.Run()
+	exitCode := 0
+	if err != nil {
+		exitCode = -1
+		if ee, ok := err.(*exec.ExitError); ok {
+			exitCode = ee.ExitCode()
+		}
+		err = &CommandError{
+			Argv:       cmd.Args,
+			ExitCode:   exitCode,
+			StderrTail: tail.String(),
+			Err:        err,
+		}
+	}
+
+	exited := Update{
+		Kind:     EventCmdExited,
+		Argv:     cmd.Args,
+		ExitCode: exitCode,
+		Duration: time.Since(start),
+	}
+	if output != nil {
+		exited.Output = output.String()
+	}
+	updateChan <- exited
+	return err
+}
+
 func runCmdInteractive(updateChan chan Update, logPrefix, cmd string, args ...string) error {
 	e := exec.Command(cmd, args...)
 	updateChan <- Update{Msg: fmt.Sprintf("  %s %s %s\n", logPrefix, cmd, args)}
@@ -74,5 +316,93 @@ func runCmdInteractive(updateChan chan Update, logPrefix, cmd string, args ...st
 		IsErr:      true,
 	}
 
-	return e.Run()
+	return runCmd(updateChan, e)
+}
+
+// progressParser extracts the completed fraction of some work from lines
+// of command output.
+type progressParser interface {
+	Parse(line string) (float64, bool)
+}
+
+var ddBytesExp = regexp.MustCompile(`^(\d+) bytes`)
+
+// ddProgress tracks the bytes written by dd status=progress against the
+// size of the device being written.
+type ddProgress struct {
+	total int64
+}
+
+func (p *ddProgress) Parse(line string) (float64, bool) {
+	m := ddBytesExp.FindStringSubmatch(line)
+	if len(m) == 0 || p.total <= 0 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return clampFraction(float64(n) / float64(p.total)), true
+}
+
+var (
+	nixFetchTotalExp = regexp.MustCompile(`^these (\d+) paths will be fetched`)
+	nixBuildTotalExp = regexp.MustCompile(`^these (\d+) derivations will be built`)
+)
+
+// nixProgress counts the store paths copied and derivations built by
+// nix against the totals it announces up front.
+type nixProgress struct {
+	total, done int
+}
+
+func (p *nixProgress) Parse(line string) (float64, bool) {
+	switch {
+	case strings.HasPrefix(line, "this path will be fetched"),
+		strings.HasPrefix(line, "this derivation will be built"):
+		p.total++
+	case strings.HasPrefix(line, "copying path '"),
+		strings.HasPrefix(line, "building '"):
+		p.done++
+	default:
+		m := nixFetchTotalExp.FindStringSubmatch(line)
+		if len(m) == 0 {
+			m = nixBuildTotalExp.FindStringSubmatch(line)
+		}
+		if len(m) == 0 {
+			return 0, false
+		}
+		n, _ := strconv.Atoi(m[1])
+		p.total += n
+	}
+
+	if p.total == 0 {
+		return 0, false
+	}
+	return clampFraction(float64(p.done) / float64(p.total)), true
+}
+
+// countProgress counts lines of output which contain sep, such as
+// the '->' printed for each file copied by cp -v.
+type countProgress struct {
+	sep         string
+	total, done int
+}
+
+func (p *countProgress) Parse(line string) (float64, bool) {
+	if !strings.Contains(line, p.sep) || p.total <= 0 {
+		return 0, false
+	}
+	p.done++
+	return clampFraction(float64(p.done) / float64(p.total)), true
+}
+
+func clampFraction(f float64) float64 {
+	if f < 0 {
+		return 0
+	}
+	if f > 1 {
+		return 1
+	}
+	return f
 }
@@ -0,0 +1,122 @@
+package install
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUpdateJSON(t *testing.T) {
+	ts := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	tcs := []struct {
+		name string
+		u    Update
+		want string
+	}{
+		{
+			name: "log",
+			u:    Update{Time: ts, Msg: "  hi\n", Level: MsgWarn},
+			want: `{"type":"log","time":"2020-04-01T12:00:00Z","msg":"  hi\n","level":"warn"}`,
+		},
+		{
+			name: "step finished",
+			u:    Update{Kind: EventStepFinished, Time: ts, Step: "format", StepName: "Format disk", Duration: 1500 * time.Millisecond},
+			want: `{"type":"step_finished","time":"2020-04-01T12:00:00Z","step":"format","step_name":"Format disk","duration_ms":1500}`,
+		},
+		{
+			name: "cmd exited",
+			u:    Update{Kind: EventCmdExited, Time: ts, Argv: []string{"sudo", "false"}, ExitCode: 1},
+			want: `{"type":"cmd_exited","time":"2020-04-01T12:00:00Z","argv":["sudo","false"],"exit_code":1,"duration_ms":0}`,
+		},
+		{
+			name: "progress",
+			u:    Update{Kind: EventProgress, Time: ts, Progress: 0.5, StepProgress: 0.25, ETA: 90 * time.Second},
+			want: `{"type":"progress","time":"2020-04-01T12:00:00Z","percent":50,"step_percent":25,"eta_s":90}`,
+		},
+		{
+			name: "failed",
+			u:    Update{Kind: EventResult, Time: ts, Err: errors.New("boom")},
+			want: `{"type":"result","time":"2020-04-01T12:00:00Z","success":false,"error":"boom"}`,
+		},
+		{
+			name: "command failed",
+			u: Update{Kind: EventResult, Time: ts, Err: &StepError{
+				StepName: "Format disk",
+				Class:    FailureDisk,
+				Err:      &CommandError{Argv: []string{"parted"}, ExitCode: 1, StderrTail: "Error: no"},
+			}},
+			want: `{"type":"result","time":"2020-04-01T12:00:00Z","success":false,"error":"step \"Format disk\" failed: parted: exit status 1 (Error: no)",` +
+				`"failure":{"class":"disk","explanation":"The target disk could not be set up. It may be in use, failing, or too small.","argv":["parted"],"exit_code":1,"stderr_tail":"Error: no"}}`,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := json.Marshal(tc.u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tc.want {
+				t.Errorf("got  %s\nwant %s", b, tc.want)
+			}
+		})
+	}
+}
+
+func TestProgressParsers(t *testing.T) {
+	type line struct {
+		in   string
+		want float64
+		ok   bool
+	}
+
+	tcs := []struct {
+		name   string
+		parser progressParser
+		lines  []line
+	}{
+		{
+			name:   "dd",
+			parser: &ddProgress{total: 4000},
+			lines: []line{
+				{in: "1000 bytes (1.0 kB, 1000 B) copied, 1 s, 1.0 kB/s", want: 0.25, ok: true},
+				{in: "dd: error writing '/dev/mapper/cryptroot': No space left on device"},
+				{in: "4096 bytes (4.1 kB, 4.0 KiB) copied, 2 s, 2.0 kB/s", want: 1, ok: true},
+			},
+		},
+		{
+			name:   "nix",
+			parser: &nixProgress{},
+			lines: []line{
+				{in: "copying channel..."},
+				{in: "these 2 derivations will be built:", want: 0, ok: true},
+				{in: "/nix/store/abc-foo.drv"},
+				{in: "these 2 paths will be fetched (1.20 MiB download, 4.00 MiB unpacked):", want: 0, ok: true},
+				{in: "copying path '/nix/store/def-bar' from 'https://cache.nixos.org'...", want: 0.25, ok: true},
+				{in: "building '/nix/store/abc-foo.drv'...", want: 0.5, ok: true},
+			},
+		},
+		{
+			name:   "cp",
+			parser: &countProgress{sep: " -> ", total: 2},
+			lines: []line{
+				{in: "'/etc/nixos' -> '/mnt/etc/nixos'", want: 0.5, ok: true},
+				{in: "cp: cannot stat 'x': No such file or directory"},
+				{in: "'/etc/nixos/a.nix' -> '/mnt/etc/nixos/a.nix'", want: 1, ok: true},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, l := range tc.lines {
+				got, ok := tc.parser.Parse(l.in)
+				if ok != l.ok || got != l.want {
+					t.Errorf("Parse(%q) = %v, %v; want %v, %v", l.in, got, ok, l.want, l.ok)
+				}
+			}
+		})
+	}
+}
@@ -1,21 +1,134 @@
 package install
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+
 	"github.com/twitchylinux/twlinst/z"
 )
 
 // Settings contains all the configuration for the installer.
 type Settings struct {
+	// Username and Password describe the primary user. Password is also
+	// the passphrase for disk encryption.
 	Username string `json:"username"`
 	Hostname string `json:"hostname"`
 	Password string `json:"password"`
 	Timezone string `json:"timezone"`
 
+	// AuthorizedKeys may be used to log in as the primary user over SSH.
+	AuthorizedKeys AuthorizedKeys `json:"authorized_keys"`
+
+	// Locale is the default locale, such as en_US.UTF-8.
+	Locale   string           `json:"locale"`
+	Keyboard KeyboardSettings `json:"keyboard"`
+
+	// Users are additional accounts to create.
+	Users []User `json:"users"`
+
+	SSH SSHSettings `json:"ssh"`
+
+	// Flake manages the installed configuration as a flake, with its inputs
+	// locked to the sources on the install medium.
+	Flake bool `json:"flake"`
+
+	// Extra packages and modules to add to the installed system.
+	Extra ExtraConfig `json:"extra"`
+
+	// Offline installs without network access.
+	Offline OfflineSettings `json:"offline"`
+
+	// Hooks are scripts run during the install, in order within each phase.
+	Hooks []Hook `json:"hooks"`
+
+	// NetworkConnections are the paths of the NetworkManager connection
+	// profiles to copy to the installed system. All profiles are copied
+	// if it is omitted.
+	NetworkConnections []string `json:"network_connections"`
+
 	Disk      z.Disk `json:"-"`
 	Scrub     bool   `json:"scrub_disk"`
 	Autologin bool   `json:"autologin"`
 
 	ConfigOnlyDisk string `json:"install_disk"`
 
-	NixosHardwareImport string `json:"nixos_hardware_import"`
+	NixosHardwareImports HardwareImports `json:"nixos_hardware_import"`
+
+	// DiagnosticsPath is where a diagnostics bundle is written if the
+	// install fails. A path alongside the log is used if empty.
+	DiagnosticsPath string `json:"diagnostics_path"`
+}
+
+// Redacted returns a copy of the settings with secrets removed.
+func (s Settings) Redacted() Settings {
+	if s.Password != "" {
+		s.Password = "<redacted>"
+	}
+	users := make([]User, len(s.Users))
+	for i, u := range s.Users {
+		if u.Password != "" {
+			u.Password = "<redacted>"
+		}
+		if u.PasswordHash != "" {
+			u.PasswordHash = "<redacted>"
+		}
+		users[i] = u
+	}
+	s.Users = users
+	return s
+}
+
+// hostnameExp matches an RFC 1123 host name label. It is also used as an
+// attribute name and URL fragment in flake mode.
+var hostnameExp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// ValidateHostname returns an error if h is not a valid host name.
+func ValidateHostname(h string) error {
+	if !hostnameExp.MatchString(h) {
+		return fmt.Errorf("invalid hostname %q: must be up to 63 letters, digits or '-', not starting or ending with '-'", h)
+	}
+	return nil
+}
+
+// ValidateNetworkConnections returns an error if any of the network
+// connections are not NetworkManager profiles.
+func (s *Settings) ValidateNetworkConnections() error {
+	for _, c := range s.NetworkConnections {
+		if filepath.Dir(filepath.Clean(c)) != z.NetworkConnectionsDir {
+			return fmt.Errorf("network connection %q is not in %s", c, z.NetworkConnectionsDir)
+		}
+	}
+	return nil
+}
+
+// HardwareImports lists the nixos-hardware profiles imported by the system
+// configuration. In JSON it may be given as a single string or a list.
+type HardwareImports []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (h *HardwareImports) UnmarshalJSON(d []byte) error {
+	var single string
+	if err := json.Unmarshal(d, &single); err == nil {
+		*h = nil
+		if single != "" {
+			*h = HardwareImports{single}
+		}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(d, &list); err != nil {
+		return errors.New("nixos_hardware_import must be a string or a list of strings")
+	}
+	*h = list
+	return nil
+}
+
+// KeyboardSettings describes the keyboard layout, as used by XKB.
+type KeyboardSettings struct {
+	Layout  string `json:"layout"`
+	Variant string `json:"variant"`
 }
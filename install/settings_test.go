@@ -0,0 +1,82 @@
+package install
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHardwareImportsJSON(t *testing.T) {
+	tcs := []struct {
+		name    string
+		in      string
+		want    HardwareImports
+		wantErr bool
+	}{
+		{"empty string", `""`, nil, false},
+		{"single", `"lenovo/thinkpad/x230"`, HardwareImports{"lenovo/thinkpad/x230"}, false},
+		{"auto", `"auto"`, HardwareImports{"auto"}, false},
+		{"list", `["lenovo/thinkpad/x230", "common/gpu/nvidia"]`, HardwareImports{"lenovo/thinkpad/x230", "common/gpu/nvidia"}, false},
+		{"number", `3`, nil, true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var s Settings
+			err := json.Unmarshal([]byte(`{"nixos_hardware_import": `+tc.in+`}`), &s)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Unmarshal() err = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(s.NixosHardwareImports, tc.want) {
+				t.Errorf("NixosHardwareImports = %q, want %q", s.NixosHardwareImports, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateNetworkConnections(t *testing.T) {
+	tcs := []struct {
+		name    string
+		conns   []string
+		wantErr bool
+	}{
+		{"all", nil, false},
+		{"none", []string{}, false},
+		{"profile", []string{"/etc/NetworkManager/system-connections/home.nmconnection"}, false},
+		{"elsewhere", []string{"/etc/shadow"}, true},
+		{"escape", []string{"/etc/NetworkManager/system-connections/../../shadow"}, true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			s := Settings{NetworkConnections: tc.conns}
+			if err := s.ValidateNetworkConnections(); (err != nil) != tc.wantErr {
+				t.Errorf("ValidateNetworkConnections() = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateHostname(t *testing.T) {
+	tcs := []struct {
+		hostname string
+		wantErr  bool
+	}{
+		{"twl", false},
+		{"my-Laptop2", false},
+		{"", true},
+		{"-twl", true},
+		{"twl-", true},
+		{"twl.local", true},
+		{"twl#x", true},
+		{`twl" + x`, true},
+		{strings.Repeat("a", 64), true},
+	}
+
+	for _, tc := range tcs {
+		if err := ValidateHostname(tc.hostname); (err != nil) != tc.wantErr {
+			t.Errorf("ValidateHostname(%q) = %v, wantErr %v", tc.hostname, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package install
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/twitchylinux/twlinst/z"
+)
+
+// defaultSSHPort is used if no port is configured.
+const defaultSSHPort = 22
+
+// SSHSettings configures the OpenSSH server on the installed system.
+type SSHSettings struct {
+	Enable bool `json:"enable"`
+	// Port is the port sshd listens on, 22 if zero.
+	Port int `json:"port"`
+	// PasswordAuth allows logging in with a password rather than a key.
+	PasswordAuth bool `json:"password_auth"`
+}
+
+// Validate returns an error if the settings are invalid.
+func (s *SSHSettings) Validate() error {
+	if s.Port < 0 || s.Port > 65535 {
+		return fmt.Errorf("invalid SSH port %d", s.Port)
+	}
+	return nil
+}
+
+// AuthorizedKeys describes where to find the SSH public keys which may be
+// used to log in as a user.
+type AuthorizedKeys struct {
+	// Keys are given inline, one per element.
+	Keys []string `json:"keys"`
+	// File is the path to a file of keys, in authorized_keys format.
+	File string `json:"file"`
+	// USBFile is the path to a file of keys, relative to the root of a
+	// USB drive or other removable media.
+	USBFile string `json:"usb_file"`
+}
+
+var sshKeyExp = regexp.MustCompile(`^(ssh-(rsa|dss|ed25519)|ecdsa-sha2-nistp(256|384|521)|sk-(ssh-ed25519|ecdsa-sha2-nistp256)@openssh\.com) [A-Za-z0-9+/]+=*( .*)?$`)
+
+// parseAuthorizedKeys returns the keys in authorized_keys formatted data,
+// ignoring blank lines and comments.
+func parseAuthorizedKeys(data string) ([]string, error) {
+	var out []string
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !sshKeyExp.MatchString(line) {
+			return nil, fmt.Errorf("line %d: not an SSH public key", i+1)
+		}
+		out = append(out, line)
+	}
+	return out, nil
+}
+
+// Resolve returns all the keys, reading them from files as needed.
+func (k *AuthorizedKeys) Resolve() ([]string, error) {
+	keys, err := parseAuthorizedKeys(strings.Join(k.Keys, "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("inline keys: %v", err)
+	}
+
+	if k.File != "" {
+		d, err := ioutil.ReadFile(k.File)
+		if err != nil {
+			return nil, err
+		}
+		fileKeys, err := parseAuthorizedKeys(string(d))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", k.File, err)
+		}
+		keys = appendUnique(keys, fileKeys...)
+	}
+
+	if k.USBFile != "" {
+		d, err := z.ReadFromRemovable(k.USBFile)
+		if err != nil {
+			return nil, err
+		}
+		usbKeys, err := parseAuthorizedKeys(string(d))
+		if err != nil {
+			return nil, fmt.Errorf("%s on USB drive: %v", k.USBFile, err)
+		}
+		keys = appendUnique(keys, usbKeys...)
+	}
+	return keys, nil
+}
+
+// ValidateAuthorizedKeys returns an error if the authorized keys of any
+// user cannot be read, so a missing file or USB drive is found before the
+// disk is formatted.
+func (s *Settings) ValidateAuthorizedKeys() error {
+	if _, err := s.AuthorizedKeys.Resolve(); err != nil {
+		return fmt.Errorf("authorized keys: %v", err)
+	}
+	for _, u := range s.AllUsers() {
+		if _, err := u.AuthorizedKeys.Resolve(); err != nil {
+			return fmt.Errorf("authorized keys for %s: %v", u.Name, err)
+		}
+	}
+	return nil
+}
+
+// resolveAuthorizedKeys returns the users with the keys from files or
+// removable media read into AuthorizedKeys.Keys.
+func resolveAuthorizedKeys(updateChan chan Update, users []User) ([]User, error) {
+	out := make([]User, len(users))
+	for i, u := range users {
+		keys, err := u.AuthorizedKeys.Resolve()
+		if err != nil {
+			return nil, fmt.Errorf("authorized keys for %s: %w", u.Name, err)
+		}
+		if len(keys) > 0 {
+			progressInfo(updateChan, "  Adding %d SSH key(s) for %s.\n", len(keys), u.Name)
+		}
+		u.AuthorizedKeys = AuthorizedKeys{Keys: keys}
+		out[i] = u
+	}
+	return out, nil
+}
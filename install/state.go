@@ -0,0 +1,122 @@
+package install
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/twitchylinux/twlinst/z"
+)
+
+// runState records which steps of an install have completed, so a
+// later run against the same disk can resume from the first incomplete one.
+type runState struct {
+	Disk      string   `json:"disk"`
+	Completed []string `json:"completed_steps"`
+}
+
+func statePath() string {
+	return filepath.Join(os.TempDir(), "twlinst-state.json")
+}
+
+func loadState() (*runState, error) {
+	d, err := ioutil.ReadFile(statePath())
+	if err != nil {
+		return nil, err
+	}
+	var s runState
+	if err := json.Unmarshal(d, &s); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", statePath(), err)
+	}
+	return &s, nil
+}
+
+func (s *runState) save() error {
+	d, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(statePath(), d, 0600)
+}
+
+// resumeIndex returns the index of the first step which has not completed.
+func (r *Run) resumeIndex(s *runState) int {
+	if s.Disk != r.config.Disk.Path {
+		return 0
+	}
+	done := make(map[string]bool, len(s.Completed))
+	for _, name := range s.Completed {
+		done[name] = true
+	}
+	for i, step := range r.steps {
+		if !done[step.Name()] {
+			return i
+		}
+	}
+	return len(r.steps)
+}
+
+// markCompleted records that the step at the given index completed.
+func (r *Run) markCompleted(idx int) error {
+	s := runState{Disk: r.config.Disk.Path}
+	for _, step := range r.steps[:idx+1] {
+		s.Completed = append(s.Completed, step.Name())
+	}
+	return s.save()
+}
+
+// partitioned returns true if the disk is partitioned by a step before the
+// step at idx, so the new filesystems must be set up again to resume from it.
+func (r *Run) partitioned(idx int) bool {
+	for _, s := range r.steps[:idx] {
+		if _, ok := s.(*PartitionStep); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareResume re-establishes the state the partition and configure steps
+// leave the system in, for resuming an install after them.
+func (r *Run) prepareResume(updateChan chan Update, mountBase string) error {
+	progressInfo(updateChan, "Preparing to resume installation.\n")
+
+	if _, err := os.Stat("/dev/mapper/cryptroot"); os.IsNotExist(err) {
+		progressInfo(updateChan, "  Unlocking root filesystem\n")
+		cmd := exec.Command("sudo", "cryptsetup", "luksOpen", "--key-file", "-", r.config.Disk.PathForPartition(2), "cryptroot")
+		cmd.Stdin = bytes.NewReader([]byte(r.config.Password))
+		if out, err := runCmdOutput(updateChan, cmd); err != nil {
+			return fmt.Errorf("luksOpen: %s (%w)", strings.TrimSpace(string(out)), err)
+		}
+		if err := z.WaitForDevice("/dev/mapper/cryptroot", deviceTimeout); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range []struct{ dev, path string }{
+		{"/dev/mapper/cryptroot", mountBase},
+		{r.config.Disk.PathForPartition(1), filepath.Join(mountBase, "boot")},
+	} {
+		mounted, err := z.IsMounted(m.path)
+		if err != nil {
+			return err
+		}
+		if mounted {
+			continue
+		}
+		if _, err := os.Stat(m.path); err != nil {
+			// Not yet created by the configure step, which will mount it.
+			continue
+		}
+		progressInfo(updateChan, "  Mounting %s -> %s\n", m.dev, m.path)
+		if out, err := runCmdOutput(updateChan, exec.Command("sudo", "mount", m.dev, m.path)); err != nil {
+			return fmt.Errorf("mount %s: %s (%w)", m.path, strings.TrimSpace(string(out)), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package install
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twitchylinux/twlinst/z"
+)
+
+func TestResumeIndex(t *testing.T) {
+	r := Configure(nil, Settings{Disk: z.Disk{Path: "/dev/sda"}})
+
+	tcs := []struct {
+		name  string
+		state runState
+		want  int
+	}{
+		{"none completed", runState{Disk: "/dev/sda"}, 0},
+		{"partitioned", runState{Disk: "/dev/sda", Completed: []string{"Format disk"}}, 1},
+		{"configured", runState{Disk: "/dev/sda", Completed: []string{"Format disk", "Configure"}}, 2},
+		{"applied", runState{Disk: "/dev/sda", Completed: []string{"Format disk", "Configure", "Apply install markers"}}, 3},
+		{"all", runState{Disk: "/dev/sda", Completed: []string{"Format disk", "Configure", "Apply install markers", "Install system"}}, 4},
+		{"different disk", runState{Disk: "/dev/sdb", Completed: []string{"Format disk"}}, 0},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := r.resumeIndex(&tc.state); got != tc.want {
+				t.Errorf("resumeIndex() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+// failStep is a step which always fails.
+type failStep struct{ name string }
+
+func (s *failStep) Exec(chan Update, *Run) error { return errors.New(s.name + " failed") }
+func (s *failStep) Stage() string                { return "check" }
+func (s *failStep) Name() string                 { return s.name }
+
+func TestRetry(t *testing.T) {
+	tmp := os.TempDir()
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+
+	tcs := []struct {
+		name        string
+		steps       []step
+		failed      int
+		wantResume  bool
+		wantFailure string
+	}{
+		{
+			name:        "before partitioning",
+			steps:       []step{&ExtraCheckStep{}, &failStep{"Check"}, &PartitionStep{}},
+			failed:      1,
+			wantFailure: "Check",
+		},
+		{
+			name:        "after partitioning",
+			steps:       []step{&PartitionStep{}, &failStep{"Configure"}},
+			failed:      1,
+			wantResume:  true,
+			wantFailure: "Configure",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			// The log and install state are kept in the temporary directory.
+			dir, _ := ioutil.TempDir(tmp, "")
+			defer os.RemoveAll(dir)
+			os.Setenv("TMPDIR", dir)
+
+			ch := make(chan Update)
+			go func() {
+				for range ch {
+				}
+			}()
+			defer close(ch)
+
+			// Fail as if the steps before the failing one had completed.
+			r := &Run{
+				uiUpdate: ch,
+				config:   Settings{Disk: z.Disk{Path: "/dev/null"}},
+				steps:    tc.steps,
+				err:      errors.New("failed"),
+				failed:   tc.failed,
+			}
+			if err := r.Retry(); err != nil {
+				t.Fatal(err)
+			}
+			err := r.Wait()
+			if err == nil {
+				t.Fatal("Wait() = nil, want the step error")
+			}
+			if got := r.FailedStep(); got != tc.wantFailure {
+				t.Errorf("FailedStep() = %q, want %q", got, tc.wantFailure)
+			}
+			if resumed := strings.Contains(err.Error(), "preparing to resume"); resumed != tc.wantResume {
+				t.Errorf("Wait() = %v, want resume prepared = %v", err, tc.wantResume)
+			}
+		})
+	}
+}
+
+// blockStep fails once release is closed.
+type blockStep struct{ release chan struct{} }
+
+func (s *blockStep) Exec(chan Update, *Run) error {
+	<-s.release
+	return errors.New("released")
+}
+func (s *blockStep) Stage() string { return "check" }
+func (s *blockStep) Name() string  { return "Block" }
+
+func TestRetryWhileRunning(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", dir)
+
+	ch := make(chan Update)
+	go func() {
+		for range ch {
+		}
+	}()
+	defer close(ch)
+
+	release := make(chan struct{})
+	r := &Run{uiUpdate: ch, steps: []step{&blockStep{release}}}
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// The run has failed before, but the updates of the current run are
+	// still being delivered.
+	r.err = errors.New("failed")
+	if err := r.Retry(); err == nil {
+		t.Error("Retry() succeeded while the install was running")
+	}
+	close(release)
+	if err := r.Wait(); err == nil {
+		t.Fatal("Wait() = nil, want the step error")
+	}
+	if r.log.f != nil {
+		t.Error("log was not closed when the install finished")
+	}
+	if err := r.Retry(); err != nil {
+		t.Fatalf("Retry() after the install finished = %v", err)
+	}
+	r.Wait()
+	if r.log.f != nil {
+		t.Error("log was not closed when the retried install finished")
+	}
+	if d, _ := ioutil.ReadFile(r.LogPath()); strings.Count(string(d), `Started "Block"`) != 2 {
+		t.Errorf("log does not record both runs:\n%s", d)
+	}
+}
+
+func TestWaitNotStarted(t *testing.T) {
+	done := make(chan error)
+	go func() { done <- Configure(nil, Settings{}).Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Wait() = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait() blocked on a run which was never started")
+	}
+}
@@ -0,0 +1,52 @@
+package install
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+)
+
+// ApplyStep processes the install markers in the staged configuration,
+// such as removing sections only needed on the live system.
+type ApplyStep struct{}
+
+func (s *ApplyStep) Exec(updateChan chan Update, run *Run) error {
+	mountBase := "/mnt"
+	etc := filepath.Join(mountBase, "etc")
+
+	for _, dir := range []string{filepath.Join(etc, "twl-base"), filepath.Join(etc, "nixos")} {
+		progressInfo(updateChan, "  Applying markers in %s.\n", dir)
+		a := &Applyer{TwlBasePath: dir, Run: run}
+		err := a.Exec()
+		for _, c := range a.Changes {
+			if c.Deleted {
+				progressInfo(updateChan, "    Deleted %s\n", c.Path)
+			} else {
+				progressInfo(updateChan, "    Modified %s\n", c.Path)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("applying markers: %w", err)
+		}
+	}
+
+	// The flake is locked to the sources as modified.
+	if run.config.Flake {
+		if err := setupFlake(updateChan, run, filepath.Join(etc, "nixos")); err != nil {
+			return err
+		}
+	}
+
+	if _, err := runCmdOutput(updateChan, exec.Command("sudo", "chown", "-R", "root", etc)); err != nil {
+		return fmt.Errorf("chown etc (root): %w", err)
+	}
+	return nil
+}
+
+func (s *ApplyStep) Name() string {
+	return "Apply install markers"
+}
+
+func (s *ApplyStep) Stage() string {
+	return "configure"
+}
@@ -6,75 +6,15 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"text/template"
-	"time"
 
 	"github.com/twitchylinux/twlinst/z"
 )
 
-const fsTmpl = `
-{config, pkgs, boot, lib, ...}:
-	{
-		boot.initrd.luks.devices = {
-			"cryptroot" = {
-				device = "/dev/disk/by-uuid/{{.LuksUUID}}";
-			};
-		};
-
-		fileSystems = {
-			"/" = {
-				device = "/dev/disk/by-uuid/{{.Ext4UUID}}";
-				fsType = "ext4";
-			};
-			"/boot" = {
-				device = "/dev/disk/by-uuid/{{.BootUUID}}";
-				fsType = "vfat";
-			};
-		};
-
-		boot.initrd.availableKernelModules = ["aesni_intel" "cryptd" "nvme" "ahci" "ata_piix" "uas" "sd_mod" "sr_mod" "xhci_pci" "sdhci_pci" ];
-	}
-`
-
-const nixCfgTmpl = `
-{lib, ...}:
-{
-	imports = [
-		../twl-base
-		./filesystems.nix
-		{{if .NixosHardwareImport -}}
-		../nixos-hardware/{{.NixosHardwareImport}}
-		{{- end}}
-	];
-
-	users.users.{{.Username}} = {
-		isNormalUser = true;
-		extraGroups = [ "wheel" "networkmanager" "video" "lp" "dialout" "users" ];
-		hashedPassword = "{{.PasswordHash}}";
-	};
-
-	time.timeZone = "{{.Timezone}}";
-	networking.hostName = "{{.Hostname}}";
-
-	{{if .Autologin -}}
-	# Automatically login on startup.
-	services.getty.autologinUser = "{{.Username}}";
-	{{- end}}
-
-	system.activationScripts.etc = import ../twl-base/user-skel/default-user-config.nix {
-		lib = lib;
-		username = "{{.Username}}";
-		autologin = {{.Autologin}};
-	};
-}
-`
-
 type ConfigureStep struct{}
 
 func (s *ConfigureStep) Exec(updateChan chan Update, run *Run) error {
 	mountBase := "/mnt"
 
-	time.Sleep(2 * time.Second)
 	if err := s.setupMounts(updateChan, run, mountBase); err != nil {
 		return err
 	}
@@ -84,12 +24,14 @@ func (s *ConfigureStep) Exec(updateChan chan Update, run *Run) error {
 	if err := s.setupFilesystemConf(updateChan, run, mountBase); err != nil {
 		return err
 	}
+	if err := s.setupHardwareConf(updateChan, run, mountBase); err != nil {
+		return err
+	}
 	if err := s.setupNixConf(updateChan, run, mountBase); err != nil {
 		return err
 	}
-
-	if out, err := exec.Command("sudo", "chown", "-R", "root", filepath.Join(mountBase, "etc")).CombinedOutput(); err != nil {
-		return fmt.Errorf("chmod etc (root): %s (%v)", strings.TrimSpace(string(out)), err)
+	if err := writeExtraFiles(updateChan, &run.config.Extra, filepath.Join(mountBase, "etc", "nixos")); err != nil {
+		return fmt.Errorf("writing extra modules: %w", err)
 	}
 	return nil
 }
@@ -97,50 +39,24 @@ func (s *ConfigureStep) Exec(updateChan chan Update, run *Run) error {
 func (s *ConfigureStep) setupNixConf(updateChan chan Update, run *Run, mountBase string) error {
 	progressInfo(updateChan, "  Writing configuration.nix.\n\n")
 
-	t, err := template.New("configuration.nix").Parse(nixCfgTmpl)
-	if err != nil {
-		return fmt.Errorf("template parse: %v", err)
-	}
-
-	f, err := os.OpenFile(filepath.Join(mountBase, "etc", "nixos", "configuration.nix"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	users, err := hashPasswords(updateChan, run.config.AllUsers())
 	if err != nil {
 		return err
 	}
-
-	mkpwd := exec.Command("mkpasswd", "-s", "-m", "sha-512")
-	mkpwd.Stdin = strings.NewReader(run.config.Password)
-	pwHash, err := mkpwd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("mkpasswd: %v", err)
+	if users, err = resolveAuthorizedKeys(updateChan, users); err != nil {
+		return err
 	}
 
-	if err := t.Execute(f, map[string]interface{}{
-		"Username":            run.config.Username,
-		"Timezone":            run.config.Timezone,
-		"PasswordHash":        strings.TrimSpace(string(pwHash)),
-		"Hostname":            run.config.Hostname,
-		"Autologin":           run.config.Autologin,
-		"NixosHardwareImport": run.config.NixosHardwareImport,
-	}); err != nil {
+	path := filepath.Join(mountBase, "etc", "nixos", "configuration.nix")
+	if err := writeNixFile(path, "", configurationNix(&run.config, users)); err != nil {
 		return fmt.Errorf("writing config: %v", err)
 	}
-
-	return f.Close()
+	return nil
 }
 
 func (s *ConfigureStep) setupFilesystemConf(updateChan chan Update, run *Run, mountBase string) error {
 	progressInfo(updateChan, "  Writing filesystems.nix.\n")
 
-	t, err := template.New("filesystems.nix").Parse(fsTmpl)
-	if err != nil {
-		return fmt.Errorf("template parse: %v", err)
-	}
-
-	f, err := os.OpenFile(filepath.Join(mountBase, "etc", "nixos", "filesystems.nix"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-
 	bootInfo, err := z.GetUdevDiskInfo(run.config.Disk.PathForPartition(1), false)
 	if err != nil {
 		return err
@@ -154,96 +70,134 @@ func (s *ConfigureStep) setupFilesystemConf(updateChan chan Update, run *Run, mo
 		return err
 	}
 
-	fmt.Printf("boot info: %+v\n\nmain info: %+v\n\n crypt info: %+v\n\n", bootInfo, mainInfo, cryptInfo)
+	progressInfo(updateChan, "  Boot UUID: %s, LUKS UUID: %s, ext4 UUID: %s\n", bootInfo.FsUUID, mainInfo.FsUUID, cryptInfo.FsUUID)
 
-	if err := t.Execute(f, map[string]interface{}{
-		"BootUUID": bootInfo.FsUUID,
-		"LuksUUID": mainInfo.FsUUID,
-		"Ext4UUID": cryptInfo.FsUUID,
-	}); err != nil {
+	path := filepath.Join(mountBase, "etc", "nixos", "filesystems.nix")
+	if err := writeNixFile(path, "", filesystemsNix(bootInfo.FsUUID, mainInfo.FsUUID, cryptInfo.FsUUID)); err != nil {
 		return fmt.Errorf("writing filesystems: %v", err)
 	}
-
-	return f.Close()
+	return nil
 }
 
-func (s *ConfigureStep) setupEtc(updateChan chan Update, run *Run, mountBase string) error {
-	if _, err := exec.Command("sudo", "mkdir", "-p", filepath.Join(mountBase, "etc")).CombinedOutput(); err != nil {
-		return err
-	}
-	if out, err := exec.Command("sudo", "chown", "nixos", filepath.Join(mountBase, "etc")).CombinedOutput(); err != nil {
-		return fmt.Errorf("chmod etc (nixos): %s (%v)", strings.TrimSpace(string(out)), err)
-	}
+func (s *ConfigureStep) setupHardwareConf(updateChan chan Update, run *Run, mountBase string) error {
+	progressInfo(updateChan, "  Writing hardware-configuration.nix.\n")
 
-	progressInfo(updateChan, "\n  Staging configuration:\n")
-	e := exec.Command("cp", "-arv", "/etc/nixos", filepath.Join(mountBase, "etc"))
-	e.Stdout = &cmdInteractiveWriter{
-		updateChan: updateChan,
-		logPrefix:  "  ",
+	hw, err := detectHardwareConfig(updateChan, mountBase)
+	if err != nil {
+		// The base modules are enough to boot most machines.
+		progressWarn(updateChan, "  Hardware detection failed, using defaults: %v\n", err)
+		hw = &hardwareConfig{}
 	}
-	e.Stderr = e.Stdout
-	if err := e.Start(); err != nil {
-		return err
+	hw = hw.withBaseModules()
+	if hw.Platform == "" && run.config.Flake {
+		// Flakes are evaluated purely, so the platform must be given.
+		hw.Platform = defaultPlatform()
 	}
-	e.Wait()
-	e = exec.Command("cp", "-ar", "/etc/twl-base", filepath.Join(mountBase, "etc"))
-	e.Stdout = &cmdInteractiveWriter{
-		updateChan: updateChan,
-		logPrefix:  "  ",
+	progressInfo(updateChan, "  Initrd modules: %s\n", strings.Join(hw.InitrdAvailableModules, " "))
+
+	path := filepath.Join(mountBase, "etc", "nixos", "hardware-configuration.nix")
+	if err := writeNixFile(path, hwConfigHeader, hw.nix()); err != nil {
+		return fmt.Errorf("writing hardware configuration: %v", err)
 	}
-	e.Stderr = e.Stdout
-	if err := e.Start(); err != nil {
-		return err
+	return nil
+}
+
+func (s *ConfigureStep) setupEtc(updateChan chan Update, run *Run, mountBase string) error {
+	if _, err := runCmdOutput(updateChan, exec.Command("sudo", "mkdir", "-p", filepath.Join(mountBase, "etc"))); err != nil {
+		return fmt.Errorf("mkdir etc: %w", err)
 	}
-	e.Wait()
-	e = exec.Command("cp", "-ar", "/etc/nixos-hardware", filepath.Join(mountBase, "etc"))
-	e.Stdout = &cmdInteractiveWriter{
-		updateChan: updateChan,
-		logPrefix:  "  ",
+	if _, err := runCmdOutput(updateChan, exec.Command("sudo", "chown", "nixos", filepath.Join(mountBase, "etc"))); err != nil {
+		return fmt.Errorf("chown etc (nixos): %w", err)
 	}
-	e.Stderr = e.Stdout
-	if err := e.Start(); err != nil {
-		return err
+
+	progressInfo(updateChan, "\n  Staging configuration:\n")
+	sources := []string{"/etc/nixos", twlBaseSource, hardwareSource}
+	progress := &countProgress{sep: " -> "}
+	for _, src := range sources {
+		n, err := countFiles(src)
+		if err != nil {
+			return err
+		}
+		progress.total += n
+	}
+
+	for i, src := range sources {
+		e := exec.Command("cp", "-arv", src, filepath.Join(mountBase, "etc"))
+		e.Stdout = &cmdInteractiveWriter{
+			updateChan: updateChan,
+			logPrefix:  "  ",
+			Quiet:      i > 0, // Only /etc/nixos is small enough to list.
+			Progress:   progress,
+		}
+		e.Stderr = &cmdInteractiveWriter{
+			updateChan: updateChan,
+			logPrefix:  "  ",
+			IsErr:      true,
+		}
+		if err := runCmd(updateChan, e); err != nil {
+			return fmt.Errorf("copying %s: %w", src, err)
+		}
 	}
-	e.Wait()
 
 	return nil
 }
 
+// countFiles returns the number of files, directories and links under
+// the given path, including itself.
+func countFiles(path string) (int, error) {
+	n := 0
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
 func (s *ConfigureStep) setupMounts(updateChan chan Update, run *Run, mountBase string) error {
 	cmd := exec.Command("sudo", "mkdir", "-p", mountBase)
-	if _, err := cmd.CombinedOutput(); err != nil {
+	if _, err := runCmdOutput(updateChan, cmd); err != nil {
 		return err
 	}
-	time.Sleep(100 * time.Millisecond)
 
-	progressInfo(updateChan, "\n  Mounting %s -> %s\n", "/dev/mapper/cryptroot", mountBase)
-	cmd = exec.Command("sudo", "mount", "/dev/mapper/cryptroot", mountBase)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
+	if err := s.mount(updateChan, "/dev/mapper/cryptroot", mountBase); err != nil {
 		return err
 	}
-	progressInfo(updateChan, "  Output: %q\n", string(out))
 	progressInfo(updateChan, "Mounted root fs.\n\n")
-	time.Sleep(3 * time.Second)
 
 	cmd = exec.Command("sudo", "mkdir", "-p", filepath.Join(mountBase, "boot"))
-	out, err = cmd.CombinedOutput()
+	if _, err := runCmdOutput(updateChan, cmd); err != nil {
+		return err
+	}
+
+	if err := s.mount(updateChan, run.config.Disk.PathForPartition(1), filepath.Join(mountBase, "boot")); err != nil {
+		return err
+	}
+	progressInfo(updateChan, "Mounted boot fs.\n")
+
+	return nil
+}
+
+// mount mounts the device at path, unless something is already mounted
+// there from a previous attempt at the install.
+func (s *ConfigureStep) mount(updateChan chan Update, dev, path string) error {
+	mounted, err := z.IsMounted(path)
 	if err != nil {
 		return err
 	}
-	time.Sleep(100 * time.Millisecond)
+	if mounted {
+		progressInfo(updateChan, "\n  %s is already mounted\n", path)
+		return nil
+	}
 
-	progressInfo(updateChan, "Mounting %s -> %s\n", run.config.Disk.PathForPartition(1), filepath.Join(mountBase, "boot"))
-	cmd = exec.Command("sudo", "mount", run.config.Disk.PathForPartition(1), filepath.Join(mountBase, "boot"))
-	out, err = cmd.CombinedOutput()
+	progressInfo(updateChan, "\n  Mounting %s -> %s\n", dev, path)
+	out, err := runCmdOutput(updateChan, exec.Command("sudo", "mount", dev, path))
 	if err != nil {
 		return err
 	}
 	progressInfo(updateChan, "  Output: %q\n", string(out))
-	progressInfo(updateChan, "Mounted boot fs.\n")
-	time.Sleep(2 * time.Second)
-
 	return nil
 }
 
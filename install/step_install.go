@@ -1,8 +1,11 @@
 package install
 
 import (
+	"fmt"
 	"os/exec"
 	"path/filepath"
+
+	"github.com/twitchylinux/twlinst/z"
 )
 
 type InstallStep struct{}
@@ -11,29 +14,60 @@ func (s *InstallStep) Exec(updateChan chan Update, run *Run) error {
 	mountBase := "/mnt"
 	progressInfo(updateChan, "Commencing installation.\n")
 
-	e := exec.Command("sudo", "nixos-install", "--no-root-passwd")
+	args := append([]string{"nixos-install", "--no-root-passwd"}, run.config.Offline.nixOptions()...)
+	if run.config.Flake {
+		args = append(args, "--flake", flakeRef(&run.config, mountBase))
+	}
+	if run.config.Offline.Enable {
+		progressInfo(updateChan, "  Installing offline, substituting only from local sources.\n")
+	}
+	e := exec.Command("sudo", args...)
 	e.Stdout = &cmdInteractiveWriter{
 		updateChan: updateChan,
 		logPrefix:  "  ",
 		IsProgress: true,
+		Progress:   &nixProgress{},
 	}
 	e.Stderr = e.Stdout
-	if err := e.Start(); err != nil {
+	if err := runCmd(updateChan, e); err != nil {
 		return err
 	}
-	e.Wait()
 
-	// Copy any network connections the user configured during installation.
-	e = exec.Command("sudo", "cp", "-rv", filepath.Join("/etc", "NetworkManager", "system-connections"), filepath.Join(mountBase, "etc", "NetworkManager"))
-	out, err := e.CombinedOutput()
-	if err != nil {
-		progressInfo(updateChan, "  Output: %q\n", string(out))
-		return err
+	if err := copyNetworkConnections(updateChan, run.config.NetworkConnections, mountBase); err != nil {
+		return fmt.Errorf("copying network connections: %w", err)
 	}
 
 	return nil
 }
 
+// copyNetworkConnections copies the given NetworkManager profiles to the
+// installed system, or all of them if conns is nil.
+func copyNetworkConnections(updateChan chan Update, conns []string, mountBase string) error {
+	if conns == nil {
+		e := exec.Command("sudo", "cp", "-rv", z.NetworkConnectionsDir, filepath.Join(mountBase, "etc", "NetworkManager"))
+		_, err := runCmdOutput(updateChan, e)
+		return err
+	}
+	if len(conns) == 0 {
+		progressInfo(updateChan, "  Not copying any network connections.\n")
+		return nil
+	}
+
+	dest := filepath.Join(mountBase, z.NetworkConnectionsDir)
+	if _, err := runCmdOutput(updateChan, exec.Command("sudo", "mkdir", "-p", "-m", "0700", dest)); err != nil {
+		return err
+	}
+	// NetworkManager ignores profiles readable by other users.
+	args := append([]string{"cp", "-v", "--preserve=mode"}, conns...)
+	_, err := runCmdOutput(updateChan, exec.Command("sudo", append(args, dest)...))
+	return err
+}
+
+// Weight returns the share of the install the step takes.
+func (s *InstallStep) Weight(run *Run) float64 {
+	return 10
+}
+
 func (s *InstallStep) Name() string {
 	return "Install system"
 }
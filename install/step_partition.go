@@ -2,10 +2,14 @@ package install
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os/exec"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/twitchylinux/twlinst/z"
 )
 
 // ByteCountDecimal pretty-formats the number of bytes.
@@ -22,6 +26,10 @@ func ByteCountDecimal(b int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "kMGTPE"[exp])
 }
 
+// deviceTimeout bounds how long to wait for a device to become ready
+// after it is created.
+const deviceTimeout = 30 * time.Second
+
 type PartitionStep struct{}
 
 func (s *PartitionStep) Exec(updateChan chan Update, run *Run) error {
@@ -45,53 +53,62 @@ func (s *PartitionStep) Exec(updateChan chan Update, run *Run) error {
 
 	progressInfo(updateChan, "\n  Parted invocation: %v\n", cmd.Args)
 
-	out, err := cmd.CombinedOutput()
+	out, err := runCmdOutput(updateChan, cmd)
 	progressInfo(updateChan, "  Output: %q\n", string(out))
 	if err != nil {
 		return err
 	}
-	time.Sleep(time.Second)
 
 	cmd = exec.Command("sudo", "partprobe", run.config.Disk.Path)
 	progressInfo(updateChan, "\n  Probing: %v\n", run.config.Disk.Path)
-	out, err = cmd.CombinedOutput()
+	out, err = runCmdOutput(updateChan, cmd)
 	progressInfo(updateChan, "  Output: %q\n", string(out))
 	if err != nil {
 		return err
 	}
-	time.Sleep(3 * time.Second)
+	for _, part := range []string{run.config.Disk.PathForPartition(1), run.config.Disk.PathForPartition(2)} {
+		if err := z.WaitForDevice(part, deviceTimeout); err != nil {
+			return err
+		}
+	}
 
 	cmd = exec.Command("sudo", "mkfs.fat", "-F32", "-n", "SYSTEM-EFI", run.config.Disk.PathForPartition(1))
 	progressInfo(updateChan, "\n  Creating fat32 EFI filesystem on %v\n", run.config.Disk.PathForPartition(1))
-	out, err = cmd.CombinedOutput()
+	out, err = runCmdOutput(updateChan, cmd)
 	progressInfo(updateChan, "  Output: %q\n", string(out))
 	if err != nil {
 		return err
 	}
-	time.Sleep(1 * time.Second)
+	if _, err := z.WaitForFsUUID(run.config.Disk.PathForPartition(1), deviceTimeout); err != nil {
+		return err
+	}
 
 	cmd = exec.Command("sudo", "cryptsetup", "luksFormat", "--type", "luks2", run.config.Disk.PathForPartition(2), "--key-file", "-",
 		"--hash", "sha256", "--cipher", "aes-xts-plain64", "--key-size", "512", "--iter-time", "2600", "--use-random")
 	progressInfo(updateChan, "\n  Creating encrypted filesystem on %v\n", run.config.Disk.PathForPartition(2))
 	progressInfo(updateChan, "  Invocation: %v\n", cmd.Args)
 	cmd.Stdin = bytes.NewReader([]byte(run.config.Password))
-	out, err = cmd.CombinedOutput()
+	out, err = runCmdOutput(updateChan, cmd)
 	progressInfo(updateChan, "  Output: %q\n", string(out))
 	if err != nil {
 		return err
 	}
-	time.Sleep(1 * time.Second)
+	if _, err := z.WaitForFsUUID(run.config.Disk.PathForPartition(2), deviceTimeout); err != nil {
+		return err
+	}
 
 	progressInfo(updateChan, "\n  Unlocking root filesystem\n")
 	cmd = exec.Command("sudo", "cryptsetup", "luksOpen", "--key-file", "-", run.config.Disk.PathForPartition(2), "cryptroot")
 	progressInfo(updateChan, "  Invocation: %v\n", cmd.Args)
 	cmd.Stdin = bytes.NewReader([]byte(run.config.Password))
-	out, err = cmd.CombinedOutput()
+	out, err = runCmdOutput(updateChan, cmd)
 	progressInfo(updateChan, "  Output: %q\n", string(out))
 	if err != nil {
 		return err
 	}
-	time.Sleep(1 * time.Second)
+	if err := z.WaitForDevice("/dev/mapper/cryptroot", deviceTimeout); err != nil {
+		return err
+	}
 
 	if run.config.Scrub {
 		if err := s.scrubEncrypted(updateChan, run); err != nil {
@@ -101,17 +118,28 @@ func (s *PartitionStep) Exec(updateChan chan Update, run *Run) error {
 
 	cmd = exec.Command("sudo", "mkfs.ext4", "-qF", "/dev/mapper/cryptroot")
 	progressInfo(updateChan, "\n  Creating ext4 filesystem on %v\n", "/dev/mapper/cryptroot")
-	out, err = cmd.CombinedOutput()
+	out, err = runCmdOutput(updateChan, cmd)
 	progressInfo(updateChan, "  Output: %q\n", string(out))
 	if err != nil {
 		return err
 	}
-	time.Sleep(1 * time.Second)
+	if _, err := z.WaitForFsUUID("/dev/mapper/cryptroot", deviceTimeout); err != nil {
+		return err
+	}
 	return nil
 }
 
 func (s *PartitionStep) scrubEncrypted(updateChan chan Update, run *Run) error {
 	progressInfo(updateChan, "\n  Scrubbing encrypted partition:\n")
+	out, err := runCmdOutput(updateChan, exec.Command("sudo", "blockdev", "--getsize64", "/dev/mapper/cryptroot"))
+	if err != nil {
+		return fmt.Errorf("reading size of cryptroot: %w", err)
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("reading size of cryptroot: %v", err)
+	}
+
 	e := exec.Command("sudo", "dd", "if=/dev/zero", "of=/dev/mapper/cryptroot", "bs=1M", "status=progress")
 	progressInfo(updateChan, "  Invocation: %v\n", e.Args)
 
@@ -119,17 +147,29 @@ func (s *PartitionStep) scrubEncrypted(updateChan chan Update, run *Run) error {
 		updateChan: updateChan,
 		logPrefix:  "  ",
 		IsProgress: true,
+		Progress:   &ddProgress{total: size},
 	}
 	e.Stderr = e.Stdout
 
-	if err := e.Start(); err != nil {
-		return err
+	// Will error when we exhaust the space on the device (intended).
+	if err := runCmd(updateChan, e); err != nil {
+		var ce *CommandError
+		if !errors.As(err, &ce) || !strings.Contains(ce.StderrTail, "No space left on device") {
+			return err
+		}
 	}
-
-	e.Wait() // Will error when we exhaust the space on the device (intended).
 	return nil
 }
 
+// Weight returns the share of the install the step takes, dominated by
+// scrubbing the disk if enabled.
+func (s *PartitionStep) Weight(run *Run) float64 {
+	if run.config.Scrub {
+		return 20
+	}
+	return 1
+}
+
 func (s *PartitionStep) Name() string {
 	return "Format disk"
 }
@@ -0,0 +1,171 @@
+package install
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/twitchylinux/twlinst/nix"
+)
+
+// substitutionFields are the settings which Substitute markers replace
+// content with, as functions returning a string, bool or []string.
+var substitutionFields = map[string]func(s *Settings) (interface{}, error){
+	"hostname": func(s *Settings) (interface{}, error) { return s.Hostname, nil },
+	"username": func(s *Settings) (interface{}, error) { return s.Username, nil },
+	"timezone": func(s *Settings) (interface{}, error) { return s.Timezone, nil },
+	"locale":   func(s *Settings) (interface{}, error) { return s.Locale, nil },
+	"keyboard": func(s *Settings) (interface{}, error) { return s.Keyboard.Layout, nil },
+
+	"autologin": func(s *Settings) (interface{}, error) { return s.Autologin, nil },
+	"ssh":       func(s *Settings) (interface{}, error) { return s.SSH.Enable, nil },
+
+	"users": func(s *Settings) (interface{}, error) {
+		var names []string
+		for _, u := range s.AllUsers() {
+			names = append(names, u.Name)
+		}
+		return names, nil
+	},
+	"hardware": func(s *Settings) (interface{}, error) { return []string(s.NixosHardwareImports), nil },
+	"packages": func(s *Settings) (interface{}, error) { return s.Extra.Packages, nil },
+	// ssh_keys are the keys of the primary user.
+	"ssh_keys": func(s *Settings) (interface{}, error) { return s.AuthorizedKeys.Resolve() },
+}
+
+// valueSyntaxes maps file extensions to functions writing substituted
+// values as literals in the language of the file. Nested lines are
+// indented with tabs. Substitutions are not supported in other files, as
+// their values could not be safely escaped.
+var valueSyntaxes = map[string]func(v interface{}) string{
+	".nix": nixValue,
+
+	".sh": shellValue, ".bash": shellValue,
+	".lua": luaValue,
+	".py":  pythonValue,
+
+	// Quoted JSON strings are also valid in these languages.
+	".js": jsonValue, ".ts": jsonValue, ".json5": jsonValue,
+	".toml": jsonValue, ".yaml": jsonValue, ".yml": jsonValue,
+}
+
+func nixValue(v interface{}) string {
+	var e nix.Expr
+	switch v := v.(type) {
+	case string:
+		e = nix.String(v)
+	case bool:
+		e = nix.Bool(v)
+	case []string:
+		e = nix.StringList(v)
+	}
+	return strings.TrimSuffix(string(nix.Format(e)), "\n")
+}
+
+func shellValue(v interface{}) string {
+	quote := func(s string) string {
+		return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+	}
+	switch v := v.(type) {
+	case string:
+		return quote(v)
+	case []string:
+		// A bash array.
+		var items []string
+		for _, s := range v {
+			items = append(items, quote(s))
+		}
+		return "(" + strings.Join(items, " ") + ")"
+	}
+	return fmt.Sprint(v)
+}
+
+func luaValue(v interface{}) string {
+	quote := func(s string) string {
+		var out strings.Builder
+		out.WriteByte('"')
+		for i := 0; i < len(s); i++ {
+			switch c := s[i]; {
+			case c == '"' || c == '\\':
+				out.WriteByte('\\')
+				out.WriteByte(c)
+			case c < ' ' || c == 0x7f:
+				fmt.Fprintf(&out, "\\%03d", c)
+			default:
+				out.WriteByte(c)
+			}
+		}
+		out.WriteByte('"')
+		return out.String()
+	}
+	switch v := v.(type) {
+	case string:
+		return quote(v)
+	case []string:
+		var items []string
+		for _, s := range v {
+			items = append(items, quote(s))
+		}
+		return "{ " + strings.Join(items, ", ") + " }"
+	}
+	return fmt.Sprint(v)
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func jsonValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return jsonString(v)
+	case []string:
+		var items []string
+		for _, s := range v {
+			items = append(items, jsonString(s))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
+
+func pythonValue(v interface{}) string {
+	if b, ok := v.(bool); ok {
+		if b {
+			return "True"
+		}
+		return "False"
+	}
+	return jsonValue(v)
+}
+
+// renderSubstitution returns the value of the field as a literal in the
+// language of the file at srcPath. Nested lines are indented from indent.
+func renderSubstitution(s *Settings, srcPath, field, indent string) (string, error) {
+	value, ok := substitutionFields[field]
+	if !ok {
+		return "", fmt.Errorf("unknown substitution %q", field)
+	}
+	literal, ok := valueSyntaxes[filepath.Ext(srcPath)]
+	if !ok {
+		return "", fmt.Errorf("substitutions are not supported in %s files", filepath.Ext(srcPath))
+	}
+	v, err := value(s)
+	if err != nil {
+		return "", fmt.Errorf("substituting %s: %v", field, err)
+	}
+
+	// Nested lines keep the indentation style of the marker.
+	unit := "  "
+	if strings.Contains(indent, "\t") {
+		unit = "\t"
+	}
+	lines := strings.Split(literal(v), "\n")
+	for i := 1; i < len(lines); i++ {
+		text := strings.TrimLeft(lines[i], "\t")
+		lines[i] = indent + strings.Repeat(unit, len(lines[i])-len(text)) + text
+	}
+	return strings.Join(lines, "\n"), nil
+}
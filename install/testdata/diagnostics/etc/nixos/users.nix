@@ -0,0 +1,7 @@
+{
+  users.users.alice = {
+    isNormalUser = true;
+    hashedPassword = "$6$saltsalt$alicehash";
+  };
+  users.users.bob.hashedPassword = "$6$saltsalt$bobhash";
+}
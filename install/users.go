@@ -0,0 +1,121 @@
+package install
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// defaultGroups are the groups every user is a member of.
+var defaultGroups = []string{"networkmanager", "video", "lp", "dialout", "users"}
+
+// shellPrograms are shells which must be enabled system-wide via
+// programs.<shell>.enable to be used as a login shell.
+var shellPrograms = map[string]bool{"zsh": true, "fish": true, "xonsh": true}
+
+var userNameExp = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// Shells are given as a package attribute name or an absolute path.
+var (
+	shellPackageExp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+	shellPathExp    = regexp.MustCompile(`^(/[A-Za-z0-9._+-]+)+$`)
+)
+
+// User describes an account created on the installed system.
+type User struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	// Password is the plaintext password, used if PasswordHash is empty.
+	Password     string `json:"password"`
+	PasswordHash string `json:"password_hash"`
+
+	// Admin users are members of the wheel group.
+	Admin  bool     `json:"admin"`
+	Groups []string `json:"groups"`
+	// Shell is the name of a package in nixpkgs, such as zsh, or the
+	// absolute path to a shell. The default shell is used if empty.
+	Shell string `json:"shell"`
+
+	AuthorizedKeys AuthorizedKeys `json:"authorized_keys"`
+}
+
+// AllGroups returns the groups the user is a member of.
+func (u *User) AllGroups() []string {
+	groups := append([]string(nil), defaultGroups...)
+	if u.Admin {
+		groups = append([]string{"wheel"}, groups...)
+	}
+	return appendUnique(groups, u.Groups...)
+}
+
+// Validate returns an error if the user cannot be created.
+func (u *User) Validate() error {
+	if !userNameExp.MatchString(u.Name) {
+		return fmt.Errorf("invalid username %q: must be lowercase letters, digits, '-' or '_'", u.Name)
+	}
+	if u.Password == "" && u.PasswordHash == "" {
+		return fmt.Errorf("user %q has no password", u.Name)
+	}
+	for _, g := range u.Groups {
+		if !userNameExp.MatchString(g) {
+			return fmt.Errorf("user %q: invalid group %q", u.Name, g)
+		}
+	}
+	if u.Shell != "" && !shellPackageExp.MatchString(u.Shell) && !shellPathExp.MatchString(u.Shell) {
+		return fmt.Errorf("user %q: invalid shell %q", u.Name, u.Shell)
+	}
+	return nil
+}
+
+// AllUsers returns the accounts to create. The primary user, described by
+// Username and Password, is first and is always an admin.
+func (s *Settings) AllUsers() []User {
+	var out []User
+	if s.Username != "" {
+		out = append(out, User{Name: s.Username, Password: s.Password, Admin: true, AuthorizedKeys: s.AuthorizedKeys})
+	}
+	return append(out, s.Users...)
+}
+
+// ValidateUsers returns an error if any of the users are invalid, or if
+// there are none.
+func (s *Settings) ValidateUsers() error {
+	users := s.AllUsers()
+	if len(users) == 0 {
+		return fmt.Errorf("no users configured")
+	}
+	seen := make(map[string]bool, len(users))
+	for _, u := range users {
+		if err := u.Validate(); err != nil {
+			return err
+		}
+		if seen[u.Name] {
+			return fmt.Errorf("user %q is specified more than once", u.Name)
+		}
+		seen[u.Name] = true
+	}
+	return nil
+}
+
+// hashPasswords returns the users with the hash of any plaintext password
+// populated.
+func hashPasswords(updateChan chan Update, users []User) ([]User, error) {
+	out := make([]User, len(users))
+	for i, u := range users {
+		if u.PasswordHash == "" {
+			// The hash is not captured with runCmdOutput, which would log it.
+			var pwHash bytes.Buffer
+			mkpwd := exec.Command("mkpasswd", "-s", "-m", "sha-512")
+			mkpwd.Stdin = strings.NewReader(u.Password)
+			mkpwd.Stdout, mkpwd.Stderr = &pwHash, &pwHash
+			if err := runCmd(updateChan, mkpwd); err != nil {
+				return nil, fmt.Errorf("mkpasswd for %s: %w", u.Name, err)
+			}
+			u.PasswordHash = strings.TrimSpace(pwHash.String())
+		}
+		out[i] = u
+	}
+	return out, nil
+}
@@ -0,0 +1,45 @@
+package install
+
+import "testing"
+
+func TestValidateUsers(t *testing.T) {
+	tcs := []struct {
+		name    string
+		s       Settings
+		wantErr bool
+	}{
+		{"primary only", Settings{Username: "alice", Password: "pw"}, false},
+		{"additional", Settings{Username: "alice", Password: "pw", Users: []User{{Name: "bob", PasswordHash: "$6$x"}}}, false},
+		{"additional only", Settings{Users: []User{{Name: "bob", Password: "pw"}}}, false},
+		{"none", Settings{}, true},
+		{"duplicate", Settings{Username: "alice", Password: "pw", Users: []User{{Name: "alice", Password: "pw"}}}, true},
+		{"no password", Settings{Username: "alice", Password: "pw", Users: []User{{Name: "bob"}}}, true},
+		{"bad name", Settings{Users: []User{{Name: "Bob Smith", Password: "pw"}}}, true},
+		{"bad group", Settings{Users: []User{{Name: "bob", Password: "pw", Groups: []string{"a b"}}}}, true},
+		{"bad shell", Settings{Users: []User{{Name: "bob", Password: "pw", Shell: "zsh; rm"}}}, true},
+		{"shell without space", Settings{Users: []User{{Name: "bob", Password: "pw", Shell: "zsh;rm"}}}, true},
+		{"shell attribute path", Settings{Users: []User{{Name: "bob", Password: "pw", Shell: "pkgs.zsh"}}}, true},
+		{"shell package", Settings{Users: []User{{Name: "bob", Password: "pw", Shell: "nushell"}}}, false},
+		{"shell path", Settings{Users: []User{{Name: "bob", Password: "pw", Shell: "/run/current-system/sw/bin/bash"}}}, false},
+		{"relative shell path", Settings{Users: []User{{Name: "bob", Password: "pw", Shell: "bin/bash"}}}, true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.s.ValidateUsers(); (err != nil) != tc.wantErr {
+				t.Errorf("ValidateUsers() = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedactedUsers(t *testing.T) {
+	s := Settings{Password: "pw", Users: []User{{Name: "bob", Password: "secret", PasswordHash: "$6$x"}}}
+	r := s.Redacted()
+	if r.Password == "pw" || r.Users[0].Password == "secret" || r.Users[0].PasswordHash == "$6$x" {
+		t.Errorf("Redacted() = %+v, still contains secrets", r)
+	}
+	if s.Users[0].Password != "secret" {
+		t.Error("Redacted() modified the original settings")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/twitchylinux/twlinst/install"
+)
+
+// lintMarkers implements the lint-markers command, which checks the install
+// markers in a directory such as twl-base without installing anything. It
+// exits with status 1 if any markers are invalid, or 2 if the check could
+// not be run, so it can be used in CI.
+func lintMarkers(args []string) {
+	fs := flag.NewFlagSet("lint-markers", flag.ExitOnError)
+	diffFlag := fs.Bool("diff", false, "Show the changes the markers would make when installing.")
+	configFlag := fs.String("config", "", "Configuration to apply the markers with when using -diff. Defaults to empty settings.")
+	includeFlag := fs.String("include", "", "Comma separated globs of files to check, instead of all files with known comment syntax.")
+	excludeFlag := fs.String("exclude", "", "Comma separated globs of files and directories to skip.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s lint-markers [flags] <dir>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	a := install.Applyer{
+		TwlBasePath: fs.Arg(0),
+		Include:     splitList(*includeFlag),
+		Exclude:     splitList(*excludeFlag),
+	}
+	diags, err := a.Lint()
+	for _, d := range diags {
+		fmt.Println(d)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Linting markers: %v\n", err)
+		os.Exit(2)
+	}
+	if len(diags) > 0 {
+		os.Exit(1)
+	}
+
+	if *diffFlag {
+		var conf install.Settings
+		if *configFlag != "" {
+			data, err := ioutil.ReadFile(*configFlag)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Reading config: %v\n", err)
+				os.Exit(2)
+			}
+			if err := json.Unmarshal(data, &conf); err != nil {
+				fmt.Fprintf(os.Stderr, "Decoding config: %v\n", err)
+				os.Exit(2)
+			}
+		}
+		if err := a.PreviewMarkers(conf, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Applying markers: %v\n", err)
+			os.Exit(2)
+		}
+	}
+}
+
+// splitList splits a comma separated flag value, ignoring empty elements.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/gotk3/gotk3/gdk"
 	"github.com/gotk3/gotk3/gtk"
 	"github.com/twitchylinux/twlinst/install"
+	"github.com/twitchylinux/twlinst/z"
 )
 
 const styling = `
@@ -98,13 +99,25 @@ func makeApp() (*App, error) {
 	}
 	a.fullGrid = obj.(*gtk.Grid)
 
+	// Hooks on the install medium always apply.
+	if a.settings.Hooks, err = install.LoadHooks(install.HooksDir); err != nil {
+		return nil, fmt.Errorf("loading hooks: %w", err)
+	}
+	if err := a.settings.ValidateHooks(); err != nil {
+		return nil, err
+	}
+
 	a.win.SetTitle("TwitchyLinux - installer")
 	a.win.Connect("destroy", a.callbackWindowDestroy)
 
 	a.panes = []pane{
 		initIntroPane(b),
+		initLocalePane(b),
+		initNetworkPane(b),
 		initSettingsPane(b),
+		initUsersPane(b),
 		initHardwarePane(b),
+		initExtraPane(b),
 		initConfirmPane(b),
 		initInstallPane(b),
 		initDonePane(b),
@@ -214,13 +227,20 @@ func mainApp(args []string) {
 }
 
 var (
-	configFlag = flag.String("config", "", "Install using the provided configuration instead of automatically.")
+	configFlag    = flag.String("config", "", "Install using the provided configuration instead of automatically.")
+	logFormatFlag = flag.String("log-format", "text", "Format of progress output when installing with -config: text or json.")
+	resumeFlag    = flag.Bool("resume", false, "When installing with -config, resume a failed install from the step which failed.")
 )
 
 func main() {
 	flag.Parse()
 	args := flag.Args()
 
+	if len(args) > 0 && args[0] == "lint-markers" {
+		lintMarkers(args[1:])
+		return
+	}
+
 	if *configFlag == "" {
 		mainApp(args)
 	} else {
@@ -258,23 +278,220 @@ func noninteractiveInstall() {
 		os.Exit(1)
 	}
 
+	if err := install.ValidateHostname(conf.Hostname); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := conf.SSH.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateLocale(&conf); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := conf.ValidateUsers(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := conf.ValidateAuthorizedKeys(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := conf.Offline.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := conf.Extra.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+		os.Exit(1)
+	}
+	dirHooks, err := install.LoadHooks(install.HooksDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Loading hooks: %v\n", err)
+		os.Exit(1)
+	}
+	conf.Hooks = append(conf.Hooks, dirHooks...)
+	if err := conf.ValidateHooks(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := conf.ValidateNetworkConnections(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateTimezone(&conf); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if conf.NixosHardwareImports, err = resolveHardwareImports(conf.NixosHardwareImports); err != nil {
+		fmt.Fprintf(os.Stderr, "Detecting hardware profile: %v\n", err)
+		os.Exit(1)
+	}
+
+	var printUpdate func(install.Update)
+	switch *logFormatFlag {
+	case "text":
+		printUpdate = printTextUpdate
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		printUpdate = func(msg install.Update) {
+			if err := enc.Encode(msg); err != nil {
+				fmt.Fprintf(os.Stderr, "Encoding update: %v\n", err)
+			}
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown log format %q\n", *logFormatFlag)
+		os.Exit(1)
+	}
+
 	// Print updates on the screen
-	upChan := make(chan install.Update, 1)
+	upChan, printed := make(chan install.Update, 1), make(chan struct{})
 	go func() {
 		for msg := range upChan {
-			if msg.Step != "" {
-				fmt.Printf("Starting stage %s\n", msg.Step)
-			}
-			if msg.Msg != "" {
-				fmt.Print(msg.Msg)
-			}
+			printUpdate(msg)
 		}
+		close(printed)
 	}()
 
 	run := install.Configure(upChan, conf)
-	if err := run.Start(); err != nil {
+	start := run.Start
+	if *resumeFlag {
+		start = run.Resume
+	}
+	if err := start(); err != nil {
 		fmt.Fprintf(os.Stderr, "Install init failed: %v\n", err)
 		os.Exit(1)
 	}
-	run.Wait()
+	fmt.Fprintf(os.Stderr, "Logging to %s\n", run.LogPath())
+	err = run.Wait()
+	close(upChan)
+	<-printed
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// validateTimezone checks the timezone is in the tz database of the live
+// system, where it can be read.
+func validateTimezone(conf *install.Settings) error {
+	if conf.Timezone == "" {
+		return errors.New("no timezone given")
+	}
+	ok, err := z.IsTimezone(conf.Timezone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Not validating timezone: %v\n", err)
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("unknown timezone %q", conf.Timezone)
+	}
+	return nil
+}
+
+// validateLocale checks the locale and keyboard layout are known to the
+// live system, where it is able to list them.
+func validateLocale(conf *install.Settings) error {
+	if conf.Locale != "" {
+		locales, err := z.ReadLocales()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Not validating locale: %v\n", err)
+		}
+		found := err != nil
+		for _, l := range locales {
+			found = found || l == conf.Locale
+		}
+		if !found {
+			return fmt.Errorf("unknown locale %q", conf.Locale)
+		}
+	}
+
+	if conf.Keyboard.Layout == "" {
+		if conf.Keyboard.Variant != "" {
+			return errors.New("keyboard variant given without a layout")
+		}
+		return nil
+	}
+	layouts, err := z.ReadXkbLayouts()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Not validating keyboard layout: %v\n", err)
+		return nil
+	}
+	l := z.FindXkbLayout(layouts, conf.Keyboard.Layout)
+	if l == nil {
+		return fmt.Errorf("unknown keyboard layout %q", conf.Keyboard.Layout)
+	}
+	if conf.Keyboard.Variant != "" && !l.HasVariant(conf.Keyboard.Variant) {
+		return fmt.Errorf("keyboard layout %q has no variant %q", conf.Keyboard.Layout, conf.Keyboard.Variant)
+	}
+	return nil
+}
+
+// resolveHardwareImports replaces an "auto" entry in the list of imports
+// with the detected hardware profile, if any.
+func resolveHardwareImports(imports install.HardwareImports) (install.HardwareImports, error) {
+	var out install.HardwareImports
+	for _, imp := range imports {
+		if imp != "auto" {
+			out = append(out, imp)
+			continue
+		}
+		detected, err := detectHardwareImport()
+		if err != nil {
+			return nil, err
+		}
+		if detected != "" {
+			out = append(out, detected)
+		}
+	}
+	return out, nil
+}
+
+// detectHardwareImport returns the import path of the nixos-hardware
+// profile which best matches the machine, or the empty string if none do.
+func detectHardwareImport() (string, error) {
+	profiles, err := loadHardwareProfiles(*nixosHardwareJson)
+	if err != nil {
+		return "", err
+	}
+	candidates, err := profiles.Detect()
+	if err != nil {
+		return "", err
+	}
+	if len(candidates) == 0 {
+		fmt.Fprintf(os.Stderr, "No hardware profile matches this machine\n")
+		return "", nil
+	}
+	fmt.Fprintf(os.Stderr, "Using detected hardware profile %q\n", candidates[0].Profile)
+	return profiles.Paths[candidates[0].Profile], nil
+}
+
+func printTextUpdate(msg install.Update) {
+	switch msg.Kind {
+	case install.EventStepStarted:
+		fmt.Printf("Starting stage %s\n", msg.Step)
+	case install.EventLog, install.EventWarning:
+		fmt.Print(msg.Msg)
+	case install.EventResult:
+		if msg.Err != nil {
+			fmt.Fprint(os.Stderr, "\n"+describeFailure(msg.Err))
+		}
+	}
+}
+
+// describeFailure explains why an install failed, for display to the user.
+func describeFailure(err error) string {
+	var se *install.StepError
+	if !errors.As(err, &se) {
+		return fmt.Sprintf("Install failed: %v\n", err)
+	}
+
+	out := fmt.Sprintf("Install failed at step %q: %s\n", se.StepName, se.Explain())
+	if ce := se.Command(); ce != nil {
+		out += fmt.Sprintf("  Command: %s\n  Exit status: %d\n", strings.Join(ce.Argv, " "), ce.ExitCode)
+	} else {
+		out += fmt.Sprintf("  Error: %v\n", se.Err)
+	}
+	return out
 }
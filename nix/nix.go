@@ -0,0 +1,327 @@
+// Package nix builds Nix expressions and prints them as Nix source.
+package nix
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expr is a Nix expression.
+type Expr interface {
+	format(p *printer)
+}
+
+// String is a Nix string, escaped as needed when printed.
+type String string
+
+// Path is a Nix path, such as ./filesystems.nix or /etc/nixos. Paths which
+// cannot be written as a path literal are printed as a concatenation with
+// a string, so they are still interpreted as a path.
+type Path string
+
+// Bool is a Nix boolean.
+type Bool bool
+
+// Int is a Nix integer.
+type Int int
+
+// Raw is Nix source which is printed verbatim, such as a reference to a
+// variable. It must never contain untrusted input.
+type Raw string
+
+// List is a Nix list.
+type List []Expr
+
+// Attr is a single attribute in an attribute set.
+type Attr struct {
+	// Key is the attribute path, such as {"networking", "hostName"}.
+	Key   []string
+	Value Expr
+	// Comment, if set, is printed on the lines preceding the attribute.
+	Comment string
+}
+
+// AttrSet is a Nix attribute set. Attributes are printed in order.
+type AttrSet []Attr
+
+// Func is a function taking an attribute set, such as {lib, ...}: body.
+type Func struct {
+	Args     []string
+	Ellipsis bool
+	Body     Expr
+}
+
+// Apply is the application of a function to arguments.
+type Apply struct {
+	Fn   Expr
+	Args []Expr
+}
+
+// Select is the selection of an attribute from an expression, such as
+// pkgs.zsh.
+type Select struct {
+	Expr Expr
+	Path []string
+}
+
+// Add is the sum of two expressions, such as the concatenation of a path
+// and a string: src + "/default.nix".
+type Add struct {
+	X, Y Expr
+}
+
+// Import returns an expression importing the path.
+func Import(p Path) Apply {
+	return Apply{Fn: Raw("import"), Args: []Expr{p}}
+}
+
+// StringList returns a list of strings.
+func StringList(s []string) List {
+	out := make(List, len(s))
+	for i := range s {
+		out[i] = String(s[i])
+	}
+	return out
+}
+
+// A returns an attribute with the given value, whose key is the
+// concatenation of the elements of key.
+func A(value Expr, key ...string) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+// Format returns the Nix source of the expression, terminated by a newline.
+func Format(e Expr) []byte {
+	var p printer
+	e.format(&p)
+	p.buf.WriteByte('\n')
+	return p.buf.Bytes()
+}
+
+type printer struct {
+	buf    bytes.Buffer
+	indent int
+}
+
+func (p *printer) newline() {
+	p.buf.WriteByte('\n')
+	p.buf.WriteString(strings.Repeat("\t", p.indent))
+}
+
+func (s String) format(p *printer) {
+	p.buf.WriteString(quote(string(s)))
+}
+
+var stringEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	`${`, `\${`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+)
+
+func quote(s string) string {
+	return `"` + stringEscaper.Replace(s) + `"`
+}
+
+var pathLiteralExp = regexp.MustCompile(`^(\.{0,2}|~)(/[a-zA-Z0-9._+\-]+)+$`)
+
+func (path Path) format(p *printer) {
+	s := string(path)
+	switch {
+	case pathLiteralExp.MatchString(s):
+		p.buf.WriteString(s)
+	case strings.HasPrefix(s, "/"):
+		p.buf.WriteString("/. + " + quote(s))
+	default:
+		p.buf.WriteString("./. + " + quote("/"+s))
+	}
+}
+
+func (b Bool) format(p *printer) {
+	p.buf.WriteString(strconv.FormatBool(bool(b)))
+}
+
+func (i Int) format(p *printer) {
+	p.buf.WriteString(strconv.Itoa(int(i)))
+}
+
+func (r Raw) format(p *printer) {
+	p.buf.WriteString(string(r))
+}
+
+// isSimple returns true if the expression is printed on a single line.
+func isSimple(e Expr) bool {
+	switch e := e.(type) {
+	case AttrSet:
+		return len(e) == 0
+	case List:
+		for _, v := range e {
+			if !isSimple(v) {
+				return false
+			}
+		}
+		return true
+	case Func:
+		return false
+	case Apply:
+		for _, a := range e.Args {
+			if !isSimple(a) {
+				return false
+			}
+		}
+		return isSimple(e.Fn)
+	}
+	return true
+}
+
+// maxListWidth is the longest list which is printed on a single line.
+const maxListWidth = 80
+
+func (l List) format(p *printer) {
+	if len(l) == 0 {
+		p.buf.WriteString("[ ]")
+		return
+	}
+	if isSimple(l) {
+		var line printer
+		line.buf.WriteString("[")
+		for _, v := range l {
+			line.buf.WriteByte(' ')
+			formatOperand(&line, v)
+		}
+		line.buf.WriteString(" ]")
+		if line.buf.Len() <= maxListWidth {
+			p.buf.Write(line.buf.Bytes())
+			return
+		}
+	}
+
+	p.buf.WriteString("[")
+	p.indent++
+	for _, v := range l {
+		p.newline()
+		formatOperand(p, v)
+	}
+	p.indent--
+	p.newline()
+	p.buf.WriteString("]")
+}
+
+var identExp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_'\-]*$`)
+
+var keywords = map[string]bool{
+	"assert": true, "else": true, "if": true, "in": true, "inherit": true,
+	"let": true, "or": true, "rec": true, "then": true, "with": true,
+}
+
+// attrName returns the attribute name, quoted if it is not an identifier.
+func attrName(s string) string {
+	if identExp.MatchString(s) && !keywords[s] {
+		return s
+	}
+	return quote(s)
+}
+
+func (s AttrSet) format(p *printer) {
+	if len(s) == 0 {
+		p.buf.WriteString("{ }")
+		return
+	}
+
+	p.buf.WriteString("{")
+	p.indent++
+	for _, a := range s {
+		if a.Comment != "" {
+			for _, line := range strings.Split(a.Comment, "\n") {
+				p.newline()
+				p.buf.WriteString(strings.TrimRight("# "+line, " "))
+			}
+		}
+		p.newline()
+		for i, k := range a.Key {
+			if i > 0 {
+				p.buf.WriteByte('.')
+			}
+			p.buf.WriteString(attrName(k))
+		}
+		p.buf.WriteString(" = ")
+		a.Value.format(p)
+		p.buf.WriteByte(';')
+	}
+	p.indent--
+	p.newline()
+	p.buf.WriteString("}")
+}
+
+func (f Func) format(p *printer) {
+	args := make([]string, len(f.Args), len(f.Args)+1)
+	for i, a := range f.Args {
+		args[i] = attrName(a)
+	}
+	if f.Ellipsis {
+		args = append(args, "...")
+	}
+	p.buf.WriteString("{" + strings.Join(args, ", ") + "}:")
+	p.newline()
+	f.Body.format(p)
+}
+
+func (s Select) format(p *printer) {
+	formatOperand(p, s.Expr)
+	for _, k := range s.Path {
+		p.buf.WriteByte('.')
+		p.buf.WriteString(attrName(k))
+	}
+}
+
+func (a Add) format(p *printer) {
+	formatOperand(p, a.X)
+	p.buf.WriteString(" + ")
+	formatOperand(p, a.Y)
+}
+
+func (a Apply) format(p *printer) {
+	// Application is left associative, so f a b needs no parentheses.
+	if fn, ok := a.Fn.(Apply); ok {
+		fn.format(p)
+	} else {
+		formatOperand(p, a.Fn)
+	}
+	for _, arg := range a.Args {
+		p.buf.WriteByte(' ')
+		formatOperand(p, arg)
+	}
+}
+
+// formatOperand formats an expression used as a function argument or
+// list element, parenthesizing it where required.
+func formatOperand(p *printer, e Expr) {
+	switch e := e.(type) {
+	case Apply, Func, Add:
+		p.buf.WriteByte('(')
+		e.format(p)
+		p.buf.WriteByte(')')
+	case Path:
+		if pathLiteralExp.MatchString(string(e)) {
+			e.format(p)
+			return
+		}
+		p.buf.WriteByte('(')
+		e.format(p)
+		p.buf.WriteByte(')')
+	case Int:
+		if e < 0 {
+			p.buf.WriteByte('(')
+			e.format(p)
+			p.buf.WriteByte(')')
+			return
+		}
+		e.format(p)
+	default:
+		e.format(p)
+	}
+}
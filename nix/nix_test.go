@@ -0,0 +1,62 @@
+package nix
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tcs := []struct {
+		name string
+		expr Expr
+		want string
+	}{
+		{"string", String("hello"), `"hello"`},
+		{"string escapes", String("a\"b\\c${d}$e\n"), `"a\"b\\c\${d}$e\n"`},
+		{"bool", Bool(true), "true"},
+		{"int", Int(-3), "-3"},
+		{"path literal", Path("../twl-base"), "../twl-base"},
+		{"absolute path", Path("/etc/nixos"), "/etc/nixos"},
+		{"path with spaces", Path("../nixos-hardware/x y"), `./. + "/../nixos-hardware/x y"`},
+		{"path with interpolation", Path("/a/${b}"), `/. + "/a/\${b}"`},
+		{"empty list", List{}, "[ ]"},
+		{"string list", StringList([]string{"a", "b"}), `[ "a" "b" ]`},
+		{"list of paths", List{Path("./a.nix"), Path("./b c.nix")}, `[ ./a.nix (./. + "/./b c.nix") ]`},
+		{
+			"long list",
+			StringList([]string{"aesni_intel", "cryptd", "nvme", "ahci", "ata_piix", "uas", "sd_mod", "sr_mod", "xhci_pci"}),
+			"[\n\t\"aesni_intel\"\n\t\"cryptd\"\n\t\"nvme\"\n\t\"ahci\"\n\t\"ata_piix\"\n\t\"uas\"\n\t\"sd_mod\"\n\t\"sr_mod\"\n\t\"xhci_pci\"\n]",
+		},
+		{"list of sets", List{AttrSet{A(Int(1), "a")}}, "[\n\t{\n\t\ta = 1;\n\t}\n]"},
+		{"empty set", AttrSet{}, "{ }"},
+		{
+			"set",
+			AttrSet{
+				A(String("x"), "networking", "hostName"),
+				{Key: []string{"fileSystems", "/boot", "fsType"}, Value: String("vfat"), Comment: "Boot partition."},
+				A(Bool(false), "with"),
+			},
+			"{\n\tnetworking.hostName = \"x\";\n\t# Boot partition.\n\tfileSystems.\"/boot\".fsType = \"vfat\";\n\t\"with\" = false;\n}",
+		},
+		{
+			"func",
+			Func{Args: []string{"lib"}, Ellipsis: true, Body: AttrSet{A(Raw("lib"), "lib")}},
+			"{lib, ...}:\n{\n\tlib = lib;\n}",
+		},
+		{
+			"import",
+			Apply{Fn: Import("../x.nix"), Args: []Expr{AttrSet{A(String("u"), "username")}}},
+			"import ../x.nix {\n\tusername = \"u\";\n}",
+		},
+		{"select", Select{Expr: Raw("pkgs"), Path: []string{"zsh", "with"}}, `pkgs.zsh."with"`},
+		{"add", Add{X: Raw("src"), Y: String("/default.nix")}, `src + "/default.nix"`},
+		{"add in list", List{Add{X: Raw("src"), Y: String("/a")}}, `[ (src + "/a") ]`},
+		{"import sum", Apply{Fn: Raw("import"), Args: []Expr{Add{X: Raw("src"), Y: String("/x.nix")}}}, `import (src + "/x.nix")`},
+		{"nested apply", Apply{Fn: Raw("lib.mkDefault"), Args: []Expr{Apply{Fn: Raw("f"), Args: []Expr{Int(1)}}}}, "lib.mkDefault (f 1)"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(Format(tc.expr)); got != tc.want+"\n" {
+				t.Errorf("Format() = %q, want %q", got, tc.want+"\n")
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 	"unsafe"
 
@@ -86,12 +87,88 @@ func (p *confirmPane) Show(settings *install.Settings, fullGrid *gtk.Grid) error
 	writeStyled("Password: ", "settingName")
 	writeStyled(strings.Repeat("*", len(settings.Password)), "")
 	writeStyled("\n", "")
+	for _, u := range settings.Users {
+		writeStyled("Additional user: ", "settingName")
+		writeStyled(u.Name, "")
+		if u.FullName != "" {
+			writeStyled(" ("+u.FullName+")", "")
+		}
+		if u.Admin {
+			writeStyled(", administrator", "")
+		}
+		writeStyled("\n", "")
+	}
+	if settings.SSH.Enable {
+		writeStyled("SSH server: ", "settingName")
+		writeStyled(fmt.Sprintf("port %d", settings.SSH.Port), "")
+		if settings.SSH.PasswordAuth {
+			writeStyled(", password login allowed", "")
+		}
+		writeStyled("\n", "")
+	}
+	if settings.Locale != "" {
+		writeStyled("Language: ", "settingName")
+		writeStyled(settings.Locale, "")
+		writeStyled("\n", "")
+	}
+	if settings.Keyboard.Layout != "" {
+		writeStyled("Keyboard layout: ", "settingName")
+		writeStyled(settings.Keyboard.Layout, "")
+		if settings.Keyboard.Variant != "" {
+			writeStyled(" ("+settings.Keyboard.Variant+")", "")
+		}
+		writeStyled("\n", "")
+	}
+	if settings.Offline.Enable {
+		writeStyled("Offline install: ", "settingName")
+		if settings.Offline.Cache != "" {
+			writeStyled("using the binary cache in "+settings.Offline.Cache+" and the live system", "")
+		} else {
+			writeStyled("using the live system's store", "")
+		}
+		writeStyled("\n", "")
+	}
+	if len(settings.Extra.Packages) > 0 {
+		writeStyled("Extra packages: ", "settingName")
+		writeStyled(strings.Join(settings.Extra.Packages, " ")+"\n", "")
+	}
+	if n := len(settings.Extra.Modules) + len(settings.Extra.Snippets); n > 0 {
+		writeStyled("Extra modules: ", "settingName")
+		writeStyled(strings.Join(baseNames(settings.Extra.Modules), ", "), "")
+		if len(settings.Extra.Snippets) > 0 {
+			if len(settings.Extra.Modules) > 0 {
+				writeStyled(", ", "")
+			}
+			writeStyled("inline configuration", "")
+		}
+		writeStyled("\n", "")
+	}
+	if len(settings.Hooks) > 0 {
+		writeStyled("Hooks: ", "settingName")
+		hooks := make([]string, len(settings.Hooks))
+		for i, h := range settings.Hooks {
+			hooks[i] = filepath.Base(h.Path) + " (" + string(h.Phase) + ")"
+		}
+		writeStyled(strings.Join(hooks, ", ")+"\n", "")
+	}
+	if settings.Flake {
+		writeStyled("Configuration: ", "settingName")
+		writeStyled("flake, with inputs locked to the install medium\n", "")
+	}
+	if settings.NetworkConnections != nil {
+		writeStyled("Network connections to copy: ", "settingName")
+		if len(settings.NetworkConnections) == 0 {
+			writeStyled("none", "")
+		}
+		writeStyled(strings.Join(baseNames(settings.NetworkConnections), ", "), "")
+		writeStyled("\n", "")
+	}
 	writeStyled("Timezone: ", "settingName")
 	writeStyled(settings.Timezone, "")
 	writeStyled("\n", "")
-	if settings.NixosHardwareImport != "" {
-		writeStyled("Hardware profile (nixos/hardware setting): ", "settingName")
-		writeStyled(settings.NixosHardwareImport, "")
+	if len(settings.NixosHardwareImports) > 0 {
+		writeStyled("Hardware profiles (nixos/hardware setting): ", "settingName")
+		writeStyled(strings.Join(settings.NixosHardwareImports, ", "), "")
 		writeStyled("\n", "")
 	}
 
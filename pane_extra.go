@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/twitchylinux/twlinst/install"
+)
+
+// extraPane lets the user add packages and Nix modules to the generated
+// configuration.
+type extraPane struct {
+	content     *gtk.Grid
+	packageCtrl *gtk.Entry
+	moduleView  *gtk.TreeView
+	moduleStore *gtk.ListStore
+	snippetBuf  *gtk.TextBuffer
+	errLabel    *gtk.Label
+
+	modules []string
+}
+
+func initExtraPane(b *gtk.Builder) *extraPane {
+	content, err := gtk.GridNew()
+	if err != nil {
+		panic(fmt.Errorf("creating grid: %w", err))
+	}
+	content.SetSizeRequest(550, 375)
+	content.SetHExpand(true)
+	content.SetVExpand(true)
+	content.SetRowSpacing(4)
+	content.SetColumnSpacing(8)
+
+	intro, err := gtk.LabelNew("Optionally add packages and configuration to the installed system. Most installs can skip this page.")
+	if err != nil {
+		panic(err)
+	}
+	intro.SetLineWrap(true)
+	intro.SetMarginBottom(8)
+	content.Attach(intro, 0, 0, 3, 1)
+
+	p := &extraPane{content: content}
+	p.packageCtrl = newFormEntry(content, 1, "Packages", "Attribute names in nixpkgs, such as git python3Packages.requests")
+
+	if p.moduleStore, err = gtk.ListStoreNew(glib.TYPE_STRING); err != nil {
+		panic(fmt.Errorf("creating list store: %w", err))
+	}
+	if p.moduleView, err = gtk.TreeViewNewWithModel(p.moduleStore); err != nil {
+		panic(fmt.Errorf("creating treeview: %w", err))
+	}
+	p.moduleView.AppendColumn(makeColumn("Module files", 0))
+	sw, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		panic(fmt.Errorf("creating scrolled window: %w", err))
+	}
+	sw.SetSizeRequest(-1, 90)
+	sw.SetShadowType(gtk.SHADOW_IN)
+	sw.Add(p.moduleView)
+	content.Attach(sw, 0, 2, 3, 1)
+
+	addBtn, err := gtk.ButtonNewWithLabel("Add module…")
+	if err != nil {
+		panic(err)
+	}
+	removeBtn, err := gtk.ButtonNewWithLabel("Remove module")
+	if err != nil {
+		panic(err)
+	}
+	addBtn.SetHAlign(gtk.ALIGN_END)
+	content.Attach(removeBtn, 1, 3, 1, 1)
+	content.Attach(addBtn, 2, 3, 1, 1)
+
+	snippetLab, err := gtk.LabelNew("Extra configuration, as a Nix module:")
+	if err != nil {
+		panic(err)
+	}
+	snippetLab.SetXAlign(0)
+	snippetLab.SetMarginTop(8)
+	content.Attach(snippetLab, 0, 4, 3, 1)
+
+	snippetView, err := gtk.TextViewNew()
+	if err != nil {
+		panic(err)
+	}
+	snippetView.SetMonospace(true)
+	if p.snippetBuf, err = snippetView.GetBuffer(); err != nil {
+		panic(err)
+	}
+	snippetSw, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		panic(fmt.Errorf("creating scrolled window: %w", err))
+	}
+	snippetSw.SetVExpand(true)
+	snippetSw.SetShadowType(gtk.SHADOW_IN)
+	snippetSw.Add(snippetView)
+	content.Attach(snippetSw, 0, 5, 3, 1)
+
+	if p.errLabel, err = gtk.LabelNew(""); err != nil {
+		panic(err)
+	}
+	p.errLabel.SetLineWrap(true)
+	if sc, err := p.errLabel.GetStyleContext(); err == nil {
+		sc.AddClass("invalidPassword")
+	}
+	content.Attach(p.errLabel, 0, 6, 3, 1)
+	content.ShowAll()
+
+	addBtn.Connect("clicked", p.callbackAdd)
+	removeBtn.Connect("clicked", p.callbackRemove)
+	return p
+}
+
+func (p *extraPane) callbackAdd() {
+	dialog, err := gtk.FileChooserDialogNewWith2Buttons("Add Nix module", nil, gtk.FILE_CHOOSER_ACTION_OPEN,
+		"Cancel", gtk.RESPONSE_CANCEL, "Add", gtk.RESPONSE_ACCEPT)
+	if err != nil {
+		panic(err)
+	}
+	defer dialog.Destroy()
+	filter, err := gtk.FileFilterNew()
+	if err != nil {
+		panic(err)
+	}
+	filter.SetName("Nix files")
+	filter.AddPattern("*.nix")
+	dialog.AddFilter(filter)
+
+	if dialog.Run() != gtk.RESPONSE_ACCEPT {
+		return
+	}
+	path := dialog.GetFilename()
+	for _, m := range p.modules {
+		if m == path {
+			return
+		}
+	}
+	p.modules = append(p.modules, path)
+	if err := p.moduleStore.SetValue(p.moduleStore.Append(), 0, path); err != nil {
+		panic(err)
+	}
+}
+
+func (p *extraPane) callbackRemove() {
+	sel, err := p.moduleView.GetSelection()
+	if err != nil {
+		return
+	}
+	model, iter, ok := sel.GetSelected()
+	if !ok {
+		return
+	}
+	path, err := model.(*gtk.TreeModel).GetPath(iter)
+	if err != nil {
+		return
+	}
+	idx := path.GetIndices()[0]
+	p.modules = append(p.modules[:idx], p.modules[idx+1:]...)
+	p.moduleStore.Remove(iter)
+}
+
+// extraConfig returns the configuration entered in the pane.
+func (p *extraPane) extraConfig() install.ExtraConfig {
+	var out install.ExtraConfig
+	pkgs, _ := p.packageCtrl.GetText()
+	out.Packages = strings.Fields(strings.Replace(pkgs, ",", " ", -1))
+	out.Modules = append([]string(nil), p.modules...)
+	start, end := p.snippetBuf.GetBounds()
+	if snippet, err := p.snippetBuf.GetText(start, end, false); err == nil && strings.TrimSpace(snippet) != "" {
+		out.Snippets = []string{snippet}
+	}
+	return out
+}
+
+func (p *extraPane) Show(settings *install.Settings, fullGrid *gtk.Grid) error {
+	fullGrid.Attach(p.content, 0, 1, 1, 1)
+	return nil
+}
+
+func (p *extraPane) Hide(settings *install.Settings, fullGrid *gtk.Grid) error {
+	currentPane, err := fullGrid.GetChildAt(0, 1)
+	if err != nil {
+		return fmt.Errorf("Failed to get current pane: %v", err)
+	}
+	fullGrid.Remove(currentPane)
+	return nil
+}
+
+func (p *extraPane) ShouldNext(settings *install.Settings, fullGrid *gtk.Grid) (bool, error) {
+	extra := p.extraConfig()
+	if err := extra.Validate(); err != nil {
+		p.errLabel.SetText(err.Error())
+		return false, nil
+	}
+	p.errLabel.SetText("")
+	settings.Extra = extra
+	return true, nil
+}
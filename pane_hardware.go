@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -29,12 +28,23 @@ func makeColumn(name string, id int) *gtk.TreeViewColumn {
 	return column
 }
 
+// Columns of the hardware profile tree store.
+const (
+	hwColText = iota
+	hwColPath
+	hwColSelected
+	hwColSelectable
+	// hwColSearch holds the text the filter entry is matched against.
+	hwColSearch
+)
+
 type hardwarePane struct {
 	treeView  *gtk.TreeView
 	treeStore *gtk.TreeStore
+	filter    *gtk.TreeModelFilter
 	content   *gtk.Grid
 
-	selection string
+	query string
 }
 
 func initHardwarePane(b *gtk.Builder) *hardwarePane {
@@ -50,6 +60,14 @@ func initHardwarePane(b *gtk.Builder) *hardwarePane {
 	}
 	obj.(*gtk.Grid).Remove(content)
 
+	searchEntry, err := gtk.SearchEntryNew()
+	if err != nil {
+		panic(fmt.Errorf("creating search entry: %w", err))
+	}
+	searchEntry.SetPlaceholderText("Filter profiles")
+	searchEntry.SetMarginStart(4)
+	searchEntry.SetMarginEnd(4)
+
 	treeView, err := gtk.TreeViewNew()
 	if err != nil {
 		panic(fmt.Errorf("creating treeview: %w", err))
@@ -60,19 +78,31 @@ func initHardwarePane(b *gtk.Builder) *hardwarePane {
 	treeView.SetMarginBottom(4)
 	treeView.SetMarginStart(4)
 	treeView.SetMarginEnd(4)
-	treeView.AppendColumn(makeColumn("Device", 0))
 
-	selection, err := treeView.GetSelection()
+	toggleRenderer, err := gtk.CellRendererToggleNew()
+	if err != nil {
+		panic(err)
+	}
+	toggleColumn, err := gtk.TreeViewColumnNew()
 	if err != nil {
 		panic(err)
 	}
-	selection.SetMode(gtk.SELECTION_SINGLE)
+	toggleColumn.PackStart(toggleRenderer, false)
+	toggleColumn.AddAttribute(toggleRenderer, "active", hwColSelected)
+	toggleColumn.AddAttribute(toggleRenderer, "visible", hwColSelectable)
+	treeView.AppendColumn(toggleColumn)
+	treeView.AppendColumn(makeColumn("Device", hwColText))
+	treeView.SetExpanderColumn(treeView.GetColumn(1))
 
-	treeStore, err := gtk.TreeStoreNew(glib.TYPE_STRING, glib.TYPE_STRING)
+	treeStore, err := gtk.TreeStoreNew(glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_BOOLEAN, glib.TYPE_BOOLEAN, glib.TYPE_STRING)
 	if err != nil {
 		panic(fmt.Errorf("creating treeview: %w", err))
 	}
-	treeView.SetModel(treeStore)
+	filter, err := treeStore.FilterNew(nil)
+	if err != nil {
+		panic(fmt.Errorf("creating tree filter: %w", err))
+	}
+	treeView.SetModel(filter)
 
 	sw, err := gtk.ScrolledWindowNew(nil, nil)
 	if err != nil {
@@ -80,33 +110,29 @@ func initHardwarePane(b *gtk.Builder) *hardwarePane {
 	}
 	sw.Add(treeView)
 
-	content.Attach(sw, 0, 1, 1, 1)
+	content.Attach(searchEntry, 0, 1, 1, 1)
+	content.Attach(sw, 0, 2, 1, 1)
 	content.ShowAll()
 
-	pane := &hardwarePane{treeView, treeStore, content, ""}
-	selection.Connect("changed", pane.selectionChanged)
+	pane := &hardwarePane{treeView, treeStore, filter, content, ""}
+	filter.SetVisibleFunc(pane.rowVisible)
+	searchEntry.Connect("search-changed", pane.searchChanged)
+	toggleRenderer.Connect("toggled", pane.rowToggled)
 
-	pane.appendRow(nil, "<none>", "<none>")
 	pane.populateFromFile()
 
 	return pane
 }
 
 func (p *hardwarePane) populateFromFile() {
-	f, err := os.Open(*nixosHardwareJson)
+	profiles, err := loadHardwareProfiles(*nixosHardwareJson)
 	if err != nil {
 		return
 	}
-	defer f.Close()
-
-	var profiles map[string]string
-	if err := json.NewDecoder(f).Decode(&profiles); err != nil {
-		return
-	}
 
 	sortedPrefixes := make([]string, 0, 32)
 	seen := make(map[string]struct{})
-	for k := range profiles {
+	for k := range profiles.Paths {
 		if idx := strings.Index(k, "-"); idx > 0 {
 			if _, exists := seen[k[:idx]]; !exists {
 				seen[k[:idx]] = struct{}{}
@@ -116,19 +142,62 @@ func (p *hardwarePane) populateFromFile() {
 	}
 	sort.Strings(sortedPrefixes)
 
+	// Prefix rows match the filter if any of their profiles do.
+	prefixSearch := map[string][]string{}
+	for k := range profiles.Paths {
+		if idx := strings.Index(k, "-"); idx > 0 {
+			prefixSearch[k[:idx]] = append(prefixSearch[k[:idx]], k)
+		}
+	}
 	prefixesTree := map[string]*gtk.TreeIter{}
 	for _, prefix := range sortedPrefixes {
-		prefixesTree[prefix] = p.appendRow(nil, prefix, "")
+		prefixesTree[prefix] = p.appendRow(nil, prefix, "", strings.Join(prefixSearch[prefix], " "))
 	}
 
-	for profile, path := range profiles {
-		if strings.HasPrefix(profile, "_") {
-			continue
+	// Detected profiles are labelled as such, and the best is preselected.
+	detected := map[string]bool{}
+	candidates, err := profiles.Detect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Detecting hardware: %v\n", err)
+	}
+	for _, c := range candidates {
+		detected[c.Profile] = true
+	}
+
+	profileRows := map[string]*gtk.TreeIter{}
+	for profile, path := range profiles.Paths {
+		text := profile
+		if idx := strings.Index(profile, "-"); idx > 0 && prefixesTree[profile[:idx]] != nil {
+			text = profile[idx+1:]
 		}
+		if detected[profile] {
+			text += " (detected)"
+		}
+
 		if idx := strings.Index(profile, "-"); idx > 0 && prefixesTree[profile[:idx]] != nil {
-			p.appendRow(prefixesTree[profile[:idx]], profile[idx+1:], path)
+			profileRows[profile] = p.appendRow(prefixesTree[profile[:idx]], text, path, profile)
 		} else {
-			p.appendRow(nil, profile, path)
+			profileRows[profile] = p.appendRow(nil, text, path, profile)
+		}
+	}
+
+	for i, c := range candidates {
+		if i == 0 {
+			if err := p.treeStore.SetValue(profileRows[c.Profile], hwColSelected, true); err != nil {
+				panic(err)
+			}
+		}
+		childPath, err := p.treeStore.GetPath(profileRows[c.Profile])
+		if err != nil {
+			continue
+		}
+		path := p.filter.ConvertChildPathToPath(childPath)
+		if path == nil {
+			continue
+		}
+		p.treeView.ExpandToPath(path)
+		if i == 0 {
+			p.treeView.ScrollToCell(path, nil, true, 0.5, 0)
 		}
 	}
 }
@@ -147,39 +216,73 @@ func (p *hardwarePane) Hide(settings *install.Settings, fullGrid *gtk.Grid) erro
 	return nil
 }
 
-func (p *hardwarePane) selectionChanged(selection *gtk.TreeSelection) {
-	var (
-		iter  *gtk.TreeIter
-		model gtk.ITreeModel
-		ok    bool
-	)
-
-	if model, iter, ok = selection.GetSelected(); ok {
-		v, _ := model.(*gtk.TreeModel).GetValue(iter, 1)
-		p.selection, _ = v.GetString()
-	} else {
-		p.selection = ""
+func (p *hardwarePane) rowVisible(model *gtk.TreeModel, iter *gtk.TreeIter) bool {
+	if p.query == "" {
+		return true
+	}
+	v, err := model.GetValue(iter, hwColSearch)
+	if err != nil {
+		return true
 	}
+	search, _ := v.GetString()
+	return strings.Contains(strings.ToLower(search), p.query)
 }
 
-func (p *hardwarePane) ShouldNext(settings *install.Settings, fullGrid *gtk.Grid) (bool, error) {
-	if p.selection == "<none>" {
-		settings.NixosHardwareImport = ""
-	} else {
-		settings.NixosHardwareImport = p.selection
+func (p *hardwarePane) searchChanged(entry *gtk.SearchEntry) {
+	text, err := entry.GetText()
+	if err != nil {
+		return
+	}
+	p.query = strings.ToLower(strings.TrimSpace(text))
+	p.filter.Refilter()
+	if p.query != "" {
+		p.treeView.ExpandAll()
 	}
-	return p.selection != "", nil
 }
 
-func (p *hardwarePane) appendRow(maybeParent *gtk.TreeIter, text, path string) *gtk.TreeIter {
-	i := p.treeStore.Append(maybeParent)
-	err := p.treeStore.SetValue(i, 0, text)
+func (p *hardwarePane) rowToggled(renderer *gtk.CellRendererToggle, path string) {
+	filterIter, err := p.filter.GetIterFromString(path)
 	if err != nil {
-		panic(err)
+		return
 	}
-	err = p.treeStore.SetValue(i, 1, path)
+	iter := p.filter.ConvertIterToChildIter(filterIter)
+	v, err := p.treeStore.GetValue(iter, hwColSelected)
 	if err != nil {
+		return
+	}
+	selected, _ := v.GoValue()
+	if err := p.treeStore.SetValue(iter, hwColSelected, !selected.(bool)); err != nil {
 		panic(err)
 	}
+}
+
+// selectedImports returns the import paths of the ticked profiles.
+func (p *hardwarePane) selectedImports() install.HardwareImports {
+	var out install.HardwareImports
+	p.treeStore.ForEach(func(model *gtk.TreeModel, _ *gtk.TreePath, iter *gtk.TreeIter) bool {
+		if v, err := model.GetValue(iter, hwColSelected); err == nil {
+			if selected, _ := v.GoValue(); selected == true {
+				v, _ := model.GetValue(iter, hwColPath)
+				path, _ := v.GetString()
+				out = append(out, path)
+			}
+		}
+		return false
+	})
+	return out
+}
+
+func (p *hardwarePane) ShouldNext(settings *install.Settings, fullGrid *gtk.Grid) (bool, error) {
+	settings.NixosHardwareImports = p.selectedImports()
+	return true, nil
+}
+
+func (p *hardwarePane) appendRow(maybeParent *gtk.TreeIter, text, path, search string) *gtk.TreeIter {
+	i := p.treeStore.Append(maybeParent)
+	for col, v := range []interface{}{text, path, false, path != "", search} {
+		if err := p.treeStore.SetValue(i, col, v); err != nil {
+			panic(err)
+		}
+	}
 	return i
 }
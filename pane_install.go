@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
+	"time"
 	"unsafe"
 
 	"github.com/gotk3/gotk3/glib"
@@ -27,6 +29,7 @@ type installPane struct {
 	updateCh chan install.Update
 	prev     *gtk.Button
 	content  *gtk.Grid
+	run      *install.Run
 
 	stepFormatLabel    *gtk.Label
 	stepCopyLabel      *gtk.Label
@@ -36,6 +39,9 @@ type installPane struct {
 	outputText   *gtk.TextView
 	outputBuffer *gtk.TextBuffer
 	scroll       *gtk.ScrolledWindow
+	progressBar  *gtk.ProgressBar
+
+	failureBox *gtk.ButtonBox
 }
 
 func initInstallPane(b *gtk.Builder) *installPane {
@@ -76,6 +82,31 @@ func initInstallPane(b *gtk.Builder) *installPane {
 		panic("couldnt find outputProgressScroller")
 	}
 	scroll := obj.(*gtk.ScrolledWindow)
+	obj, err = b.GetObject("installProgressBar")
+	if err != nil {
+		panic("couldnt find installProgressBar")
+	}
+	progressBar := obj.(*gtk.ProgressBar)
+	obj, err = b.GetObject("installFailureBox")
+	if err != nil {
+		panic("couldnt find installFailureBox")
+	}
+	failureBox := obj.(*gtk.ButtonBox)
+	obj, err = b.GetObject("saveLogBtn")
+	if err != nil {
+		panic("couldnt find saveLogBtn")
+	}
+	saveLogBtn := obj.(*gtk.Button)
+	obj, err = b.GetObject("saveDiagnosticsBtn")
+	if err != nil {
+		panic("couldnt find saveDiagnosticsBtn")
+	}
+	saveDiagnosticsBtn := obj.(*gtk.Button)
+	obj, err = b.GetObject("retryBtn")
+	if err != nil {
+		panic("couldnt find retryBtn")
+	}
+	retryBtn := obj.(*gtk.Button)
 
 	ttt, err := gtk.TextTagTableNew()
 	if err != nil {
@@ -106,6 +137,7 @@ func initInstallPane(b *gtk.Builder) *installPane {
 		updateCh,
 		prev,
 		content,
+		nil,
 		stepFormatLabel,
 		stepCopyLabel,
 		stepConfigureLabel,
@@ -113,7 +145,12 @@ func initInstallPane(b *gtk.Builder) *installPane {
 		output,
 		textBuffer,
 		scroll,
+		progressBar,
+		failureBox,
 	}
+	saveLogBtn.Connect("clicked", p.callbackSaveLog)
+	saveDiagnosticsBtn.Connect("clicked", p.callbackSaveDiagnostics)
+	retryBtn.Connect("clicked", p.callbackRetry)
 
 	go p.updater()
 	return p
@@ -132,11 +169,17 @@ func (p *installPane) updater() {
 	var outText string
 	sync := make(chan bool)
 	for msg := range p.updateCh {
-		if msg.Complete {
-			p.done = true
+		if msg.Kind == install.EventResult {
+			p.done = msg.Complete
+			if !msg.Complete {
+				msg.Msg, msg.Level = "\n"+describeFailure(msg.Err), install.MsgErr
+				glib.IdleAdd(func() {
+					p.failureBox.SetVisible(true)
+				})
+			}
 		}
 
-		if msg.Step != "" {
+		if msg.Kind == install.EventStepStarted {
 			glib.IdleAdd(func() {
 				applyBold(p.stepFormatLabel, msg.Step == "format")
 				applyBold(p.stepConfigureLabel, msg.Step == "configure")
@@ -145,6 +188,13 @@ func (p *installPane) updater() {
 			})
 		}
 
+		if msg.Kind == install.EventProgress {
+			glib.IdleAdd(func() {
+				p.progressBar.SetFraction(msg.Progress)
+				p.progressBar.SetText(progressText(msg.Progress, msg.ETA))
+			})
+		}
+
 		if msg.TrimLastLine {
 			// Cut out up to the 2nd-last newline.
 			i := strings.LastIndex(outText, "\n")
@@ -193,12 +243,75 @@ func (p *installPane) updater() {
 	}
 }
 
+func progressText(progress float64, eta time.Duration) string {
+	if progress >= 1 {
+		return "Done"
+	}
+	if eta <= 0 {
+		return fmt.Sprintf("%.0f%%", progress*100)
+	}
+	return fmt.Sprintf("%.0f%% - about %s remaining", progress*100, eta)
+}
+
 func (p *installPane) Show(settings *install.Settings, fullGrid *gtk.Grid) error {
 	fullGrid.Attach(p.content, 0, 1, 1, 1)
 	p.prev.SetSensitive(false)
 
-	run := install.Configure(p.updateCh, *settings)
-	return run.Start()
+	p.run = install.Configure(p.updateCh, *settings)
+	return p.run.Start()
+}
+
+// saveFile prompts the user for a location, and copies the file at srcPath
+// there.
+func (p *installPane) saveFile(title, srcPath string) {
+	dialog, err := gtk.FileChooserDialogNewWith2Buttons(title, nil, gtk.FILE_CHOOSER_ACTION_SAVE,
+		"Cancel", gtk.RESPONSE_CANCEL, "Save", gtk.RESPONSE_ACCEPT)
+	if err != nil {
+		panic(err)
+	}
+	defer dialog.Destroy()
+	dialog.SetDoOverwriteConfirmation(true)
+	dialog.SetCurrentName(filepath.Base(srcPath))
+
+	if dialog.Run() != gtk.RESPONSE_ACCEPT {
+		return
+	}
+	dest := dialog.GetFilename()
+
+	msg := install.Update{Msg: fmt.Sprintf("Saved %s to %s\n", filepath.Base(srcPath), dest)}
+	if err := copyFile(srcPath, dest); err != nil {
+		msg = install.Update{Msg: fmt.Sprintf("Failed to save %s: %v\n", filepath.Base(srcPath), err), Level: install.MsgErr}
+	}
+	go func() {
+		p.updateCh <- msg
+	}()
+}
+
+func (p *installPane) callbackSaveLog() {
+	if p.run == nil {
+		return
+	}
+	p.saveFile("Save install log", p.run.LogPath())
+}
+
+func (p *installPane) callbackRetry() {
+	if p.run == nil {
+		return
+	}
+	if err := p.run.Retry(); err != nil {
+		go func() {
+			p.updateCh <- install.Update{Msg: fmt.Sprintf("Cannot retry: %v\n", err), Level: install.MsgErr}
+		}()
+		return
+	}
+	p.failureBox.SetVisible(false)
+}
+
+func (p *installPane) callbackSaveDiagnostics() {
+	if p.run == nil {
+		return
+	}
+	p.saveFile("Save diagnostics bundle", p.run.DiagnosticsPath())
 }
 
 func (p *installPane) Hide(settings *install.Settings, fullGrid *gtk.Grid) error {
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/twitchylinux/twlinst/install"
+	"github.com/twitchylinux/twlinst/z"
+)
+
+// defaultXkbLayout is selected if the current layout is unknown.
+const defaultXkbLayout = "us"
+
+// localePane selects the language and keyboard layout. Its widgets are
+// built in code rather than from layout.glade.
+type localePane struct {
+	content *gtk.Grid
+
+	localeCtrl, layoutCtrl, variantCtrl *gtk.ComboBoxText
+
+	layouts []z.XkbLayout
+}
+
+func newCombo() *gtk.ComboBoxText {
+	c, err := gtk.ComboBoxTextNew()
+	if err != nil {
+		panic(fmt.Errorf("creating combo box: %w", err))
+	}
+	c.SetHExpand(true)
+	return c
+}
+
+func initLocalePane(b *gtk.Builder) *localePane {
+	content, err := gtk.GridNew()
+	if err != nil {
+		panic(fmt.Errorf("creating grid: %w", err))
+	}
+	content.SetSizeRequest(550, 375)
+	content.SetHExpand(true)
+	content.SetRowSpacing(6)
+	content.SetColumnSpacing(8)
+
+	intro, err := gtk.LabelNew("Choose your language and keyboard layout. The layout is also used to unlock the disk when the computer starts.")
+	if err != nil {
+		panic(err)
+	}
+	intro.SetLineWrap(true)
+	intro.SetMarginBottom(18)
+	content.Attach(intro, 0, 0, 3, 1)
+
+	p := &localePane{
+		content:     content,
+		localeCtrl:  newCombo(),
+		layoutCtrl:  newCombo(),
+		variantCtrl: newCombo(),
+	}
+	attachFormRow(content, 1, "Language", p.localeCtrl)
+	attachFormRow(content, 2, "Keyboard layout", p.layoutCtrl)
+	attachFormRow(content, 3, "Variant", p.variantCtrl)
+
+	testEntry, err := gtk.EntryNew()
+	if err != nil {
+		panic(err)
+	}
+	testEntry.SetPlaceholderText("Type here to test the keyboard layout")
+	attachFormRow(content, 4, "Test", testEntry)
+	content.ShowAll()
+
+	// Prefer the language of the live system.
+	current := strings.Replace(os.Getenv("LANG"), ".utf8", ".UTF-8", 1)
+	locales, err := z.ReadLocales()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Reading locales: %v\n", err)
+		locales = []string{z.DefaultLocale}
+	}
+	for _, l := range locales {
+		p.localeCtrl.Append(l, l)
+	}
+	if !p.localeCtrl.SetActiveID(current) && !p.localeCtrl.SetActiveID(z.DefaultLocale) {
+		p.localeCtrl.SetActive(0)
+	}
+
+	if p.layouts, err = z.ReadXkbLayouts(); err != nil {
+		fmt.Fprintf(os.Stderr, "Reading keyboard layouts: %v\n", err)
+		p.layouts = []z.XkbLayout{{Name: defaultXkbLayout, Description: "English (US)"}}
+	}
+	for _, l := range p.layouts {
+		p.layoutCtrl.Append(l.Name, l.Description)
+	}
+	p.layoutCtrl.SetActiveID(defaultXkbLayout)
+	p.populateVariants()
+
+	p.layoutCtrl.Connect("changed", p.callbackLayoutChanged)
+	p.variantCtrl.Connect("changed", p.callbackVariantChanged)
+	return p
+}
+
+func (p *localePane) populateVariants() {
+	p.variantCtrl.RemoveAll()
+	p.variantCtrl.Append("", "Default")
+	if l := z.FindXkbLayout(p.layouts, p.layoutCtrl.GetActiveID()); l != nil {
+		for _, v := range l.Variants {
+			p.variantCtrl.Append(v.Name, v.Description)
+		}
+	}
+	p.variantCtrl.SetActiveID("")
+}
+
+func (p *localePane) callbackLayoutChanged() {
+	p.populateVariants()
+	p.applyLayout()
+}
+
+func (p *localePane) callbackVariantChanged() {
+	p.applyLayout()
+}
+
+// applyLayout switches the live system to the selected layout, so it
+// can be tested and is used for the rest of the install.
+func (p *localePane) applyLayout() {
+	layout := p.layoutCtrl.GetActiveID()
+	if layout == "" {
+		return
+	}
+	if err := z.SetXkbLayout(layout, p.variantCtrl.GetActiveID()); err != nil {
+		fmt.Fprintf(os.Stderr, "Applying keyboard layout: %v\n", err)
+	}
+}
+
+func (p *localePane) Show(settings *install.Settings, fullGrid *gtk.Grid) error {
+	fullGrid.Attach(p.content, 0, 1, 1, 1)
+	return nil
+}
+
+func (p *localePane) Hide(settings *install.Settings, fullGrid *gtk.Grid) error {
+	currentPane, err := fullGrid.GetChildAt(0, 1)
+	if err != nil {
+		return fmt.Errorf("Failed to get current pane: %v", err)
+	}
+	fullGrid.Remove(currentPane)
+	return nil
+}
+
+func (p *localePane) ShouldNext(settings *install.Settings, fullGrid *gtk.Grid) (bool, error) {
+	settings.Locale = p.localeCtrl.GetActiveID()
+	settings.Keyboard = install.KeyboardSettings{
+		Layout:  p.layoutCtrl.GetActiveID(),
+		Variant: p.variantCtrl.GetActiveID(),
+	}
+	return settings.Keyboard.Layout != "", nil
+}
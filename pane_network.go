@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/twitchylinux/twlinst/install"
+	"github.com/twitchylinux/twlinst/z"
+)
+
+// Columns of the network list store.
+const (
+	netColName = iota
+	netColType
+	netColSignal
+	netColState
+	// netColIndex is the index into networkPane.networks.
+	netColIndex
+)
+
+// Columns of the saved connection list store.
+const (
+	savedColKeep = iota
+	savedColName
+	savedColType
+	savedColFile
+)
+
+// network is a row of the network list: a wired device, or a Wi-Fi
+// network seen by a wireless device.
+type network struct {
+	dev z.NetDevice
+	ap  *z.AccessPoint
+}
+
+// networkPane lets the user connect to a network using NetworkManager,
+// and choose which saved connections are copied to the installed system.
+type networkPane struct {
+	content    *gtk.Grid
+	statusLab  *gtk.Label
+	netView    *gtk.TreeView
+	netStore   *gtk.ListStore
+	savedStore *gtk.ListStore
+	passCtrl   *gtk.Entry
+	errLabel   *gtk.Label
+
+	nm       *z.NetworkManager
+	devices  []z.NetDevice
+	networks []network
+	// skip holds the saved connections the user chose not to copy.
+	skip map[string]bool
+	// timer refreshes the status while the pane is shown.
+	timer glib.SourceHandle
+}
+
+func initNetworkPane(b *gtk.Builder) *networkPane {
+	content, err := gtk.GridNew()
+	if err != nil {
+		panic(fmt.Errorf("creating grid: %w", err))
+	}
+	content.SetSizeRequest(550, 375)
+	content.SetHExpand(true)
+	content.SetVExpand(true)
+	content.SetRowSpacing(4)
+	content.SetColumnSpacing(8)
+
+	p := &networkPane{content: content, skip: map[string]bool{}}
+
+	if p.statusLab, err = gtk.LabelNew(""); err != nil {
+		panic(err)
+	}
+	p.statusLab.SetXAlign(0)
+	p.statusLab.SetHExpand(true)
+	content.Attach(p.statusLab, 0, 0, 2, 1)
+	scanBtn, err := gtk.ButtonNewWithLabel("Scan")
+	if err != nil {
+		panic(err)
+	}
+	content.Attach(scanBtn, 2, 0, 1, 1)
+
+	if p.netStore, err = gtk.ListStoreNew(glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_INT); err != nil {
+		panic(fmt.Errorf("creating list store: %w", err))
+	}
+	if p.netView, err = gtk.TreeViewNewWithModel(p.netStore); err != nil {
+		panic(fmt.Errorf("creating treeview: %w", err))
+	}
+	p.netView.SetVExpand(true)
+	p.netView.AppendColumn(makeColumn("Network", netColName))
+	p.netView.AppendColumn(makeColumn("Type", netColType))
+	p.netView.AppendColumn(makeColumn("Signal", netColSignal))
+	p.netView.AppendColumn(makeColumn("Status", netColState))
+	sw, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		panic(fmt.Errorf("creating scrolled window: %w", err))
+	}
+	sw.Add(p.netView)
+	content.Attach(sw, 0, 1, 3, 1)
+
+	p.passCtrl = newFormEntry(content, 2, "Passphrase", "For secured Wi-Fi networks")
+	p.passCtrl.SetVisibility(false)
+
+	if p.errLabel, err = gtk.LabelNew(""); err != nil {
+		panic(err)
+	}
+	p.errLabel.SetLineWrap(true)
+	if sc, err := p.errLabel.GetStyleContext(); err == nil {
+		sc.AddClass("invalidPassword")
+	}
+	content.Attach(p.errLabel, 0, 3, 2, 1)
+	connectBtn, err := gtk.ButtonNewWithLabel("Connect")
+	if err != nil {
+		panic(err)
+	}
+	content.Attach(connectBtn, 2, 3, 1, 1)
+
+	savedLab, err := gtk.LabelNew("Saved connections to copy to the installed system:")
+	if err != nil {
+		panic(err)
+	}
+	savedLab.SetXAlign(0)
+	savedLab.SetMarginTop(8)
+	content.Attach(savedLab, 0, 4, 3, 1)
+
+	if p.savedStore, err = gtk.ListStoreNew(glib.TYPE_BOOLEAN, glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING); err != nil {
+		panic(fmt.Errorf("creating list store: %w", err))
+	}
+	savedView, err := gtk.TreeViewNewWithModel(p.savedStore)
+	if err != nil {
+		panic(fmt.Errorf("creating treeview: %w", err))
+	}
+	toggleRenderer, err := gtk.CellRendererToggleNew()
+	if err != nil {
+		panic(err)
+	}
+	toggleColumn, err := gtk.TreeViewColumnNewWithAttribute("Copy", toggleRenderer, "active", savedColKeep)
+	if err != nil {
+		panic(err)
+	}
+	savedView.AppendColumn(toggleColumn)
+	savedView.AppendColumn(makeColumn("Connection", savedColName))
+	savedView.AppendColumn(makeColumn("Type", savedColType))
+	savedSw, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		panic(fmt.Errorf("creating scrolled window: %w", err))
+	}
+	savedSw.SetSizeRequest(-1, 90)
+	savedSw.Add(savedView)
+	content.Attach(savedSw, 0, 5, 3, 1)
+	content.ShowAll()
+
+	scanBtn.Connect("clicked", p.callbackScan)
+	connectBtn.Connect("clicked", p.callbackConnect)
+	toggleRenderer.Connect("toggled", p.savedToggled)
+
+	if p.nm, err = z.ConnectNetworkManager(); err != nil {
+		fmt.Fprintf(os.Stderr, "Connecting to NetworkManager: %v\n", err)
+		p.statusLab.SetText("NetworkManager is not available. All saved connections will be copied.")
+		for _, w := range []gtk.IWidget{scanBtn, connectBtn, p.passCtrl, p.netView, savedView} {
+			w.ToWidget().SetSensitive(false)
+		}
+	}
+	return p
+}
+
+func (p *networkPane) refresh() {
+	if p.nm == nil {
+		return
+	}
+	p.refreshStatus()
+	p.refreshNetworks()
+	p.refreshSaved()
+}
+
+func (p *networkPane) refreshStatus() {
+	c, err := p.nm.Connectivity()
+	if err != nil {
+		p.statusLab.SetText(fmt.Sprintf("Network status unknown: %v", err))
+		return
+	}
+	p.statusLab.SetText("Network status: " + c.String())
+}
+
+func (p *networkPane) refreshNetworks() {
+	devices, err := p.nm.Devices()
+	if err != nil {
+		p.errLabel.SetText(err.Error())
+		return
+	}
+
+	p.devices = devices
+	p.networks = p.networks[:0]
+	for _, d := range devices {
+		if d.Type != z.NetDeviceWifi {
+			p.networks = append(p.networks, network{dev: d})
+			continue
+		}
+		aps, err := p.nm.AccessPoints(d)
+		if err != nil {
+			p.errLabel.SetText(err.Error())
+			continue
+		}
+		for i := range aps {
+			p.networks = append(p.networks, network{dev: d, ap: &aps[i]})
+		}
+	}
+
+	p.netStore.Clear()
+	for i, n := range p.networks {
+		name, typ, signal, state := n.dev.Interface, n.dev.Type.String(), "", n.dev.State.String()
+		if n.ap != nil {
+			name, signal = n.ap.SSID, fmt.Sprintf("%d%%", n.ap.Strength)
+			if n.ap.Secured {
+				typ += " (secured)"
+			}
+			// The device state only applies to the network it is on.
+			if n.dev.ActiveSSID != n.ap.SSID {
+				state = ""
+			}
+		}
+		if err := p.netStore.Set(p.netStore.Append(),
+			[]int{netColName, netColType, netColSignal, netColState, netColIndex},
+			[]interface{}{name, typ, signal, state, i}); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (p *networkPane) refreshSaved() {
+	saved, err := p.nm.SavedConnections()
+	if err != nil {
+		p.errLabel.SetText(err.Error())
+		return
+	}
+	p.savedStore.Clear()
+	for _, c := range saved {
+		if !c.Persistent() {
+			continue
+		}
+		if err := p.savedStore.Set(p.savedStore.Append(),
+			[]int{savedColKeep, savedColName, savedColType, savedColFile},
+			[]interface{}{!p.skip[c.Filename], c.ID, c.Type, c.Filename}); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (p *networkPane) callbackScan() {
+	p.errLabel.SetText("")
+	for _, d := range p.devices {
+		if d.Type == z.NetDeviceWifi {
+			if err := p.nm.RequestScan(d); err != nil {
+				p.errLabel.SetText(err.Error())
+			}
+		}
+	}
+	// Scan results arrive over the following seconds.
+	glib.TimeoutAdd(3000, func() bool {
+		p.refreshNetworks()
+		return false
+	})
+}
+
+func (p *networkPane) callbackConnect() {
+	sel, err := p.netView.GetSelection()
+	if err != nil {
+		return
+	}
+	model, iter, ok := sel.GetSelected()
+	if !ok {
+		p.errLabel.SetText("Select a network to connect to.")
+		return
+	}
+	v, err := model.(*gtk.TreeModel).GetValue(iter, netColIndex)
+	if err != nil {
+		return
+	}
+	idx, _ := v.GoValue()
+	n := p.networks[idx.(int)]
+
+	if n.ap == nil {
+		err = p.nm.ConnectWired(n.dev)
+	} else {
+		pass, _ := p.passCtrl.GetText()
+		err = p.nm.ConnectWifi(n.dev, *n.ap, pass)
+	}
+	if err != nil {
+		p.errLabel.SetText(err.Error())
+		return
+	}
+	p.errLabel.SetText("")
+	p.passCtrl.SetText("")
+	p.refresh()
+}
+
+func (p *networkPane) savedToggled(renderer *gtk.CellRendererToggle, path string) {
+	iter, err := p.savedStore.GetIterFromString(path)
+	if err != nil {
+		return
+	}
+	v, err := p.savedStore.GetValue(iter, savedColKeep)
+	if err != nil {
+		return
+	}
+	keep, _ := v.GoValue()
+	v, _ = p.savedStore.GetValue(iter, savedColFile)
+	file, _ := v.GetString()
+	p.skip[file] = keep.(bool)
+	if err := p.savedStore.SetValue(iter, savedColKeep, !keep.(bool)); err != nil {
+		panic(err)
+	}
+}
+
+// keptConnections returns the saved connections ticked to be copied.
+func (p *networkPane) keptConnections() []string {
+	out := []string{}
+	p.savedStore.ForEach(func(model *gtk.TreeModel, _ *gtk.TreePath, iter *gtk.TreeIter) bool {
+		if v, err := model.GetValue(iter, savedColKeep); err == nil {
+			if keep, _ := v.GoValue(); keep == true {
+				v, _ := model.GetValue(iter, savedColFile)
+				file, _ := v.GetString()
+				out = append(out, file)
+			}
+		}
+		return false
+	})
+	return out
+}
+
+func (p *networkPane) Show(settings *install.Settings, fullGrid *gtk.Grid) error {
+	fullGrid.Attach(p.content, 0, 1, 1, 1)
+	p.refresh()
+	if p.nm != nil && p.timer == 0 {
+		p.timer = glib.TimeoutAdd(2000, func() bool {
+			p.refreshStatus()
+			return true
+		})
+	}
+	return nil
+}
+
+func (p *networkPane) Hide(settings *install.Settings, fullGrid *gtk.Grid) error {
+	if p.timer != 0 {
+		glib.SourceRemove(p.timer)
+		p.timer = 0
+	}
+	currentPane, err := fullGrid.GetChildAt(0, 1)
+	if err != nil {
+		return fmt.Errorf("Failed to get current pane: %v", err)
+	}
+	fullGrid.Remove(currentPane)
+	return nil
+}
+
+func (p *networkPane) ShouldNext(settings *install.Settings, fullGrid *gtk.Grid) (bool, error) {
+	// Without NetworkManager the default of copying everything applies.
+	if p.nm == nil {
+		settings.NetworkConnections = nil
+		return true, nil
+	}
+	settings.NetworkConnections = p.keptConnections()
+	return true, nil
+}
+
+// baseNames returns the file names of the paths, such as connection
+// profiles or modules, for display.
+func baseNames(paths []string) []string {
+	out := make([]string, len(paths))
+	for i, p := range paths {
+		out[i] = filepath.Base(p)
+	}
+	return out
+}
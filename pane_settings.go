@@ -2,10 +2,7 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
-	"path"
 	"strings"
-	"unicode"
 
 	"github.com/gotk3/gotk3/gtk"
 	"github.com/twitchylinux/twlinst/install"
@@ -17,13 +14,25 @@ type settingsPane struct {
 
 	hostCtrl, userCtrl *gtk.Entry
 
-	tzCtrl, diskCtrl   *gtk.ComboBoxText
+	tzPicker           *tzPicker
+	diskCtrl           *gtk.ComboBoxText
 	pwCtrl, pwConfirm  *gtk.Entry
 	pwLabel, hostLabel *gtk.Label
 	userLabel          *gtk.Label
 	scrubCheck         *gtk.CheckButton
 	loginCheck         *gtk.CheckButton
 
+	sshCheck, sshPwCheck *gtk.CheckButton
+	sshPort              *gtk.SpinButton
+	sshKeys              *gtk.Entry
+	sshKeysLabel         *gtk.Label
+	sshKeysHelp          string
+
+	offlineCheck      *gtk.CheckButton
+	offlineCache      *gtk.Entry
+	offlineCacheLabel *gtk.Label
+	flakeCheck        *gtk.CheckButton
+
 	disks []z.Disk
 }
 
@@ -40,11 +49,12 @@ func initSettingsPane(b *gtk.Builder) *settingsPane {
 	}
 	obj.(*gtk.Grid).Remove(content)
 
-	obj, err = b.GetObject("timezoneCombo")
+	obj, err = b.GetObject("timezoneBox")
 	if err != nil {
-		panic("couldnt find timezoneCombo")
+		panic("couldnt find timezoneBox")
 	}
-	tzCtrl := obj.(*gtk.ComboBoxText)
+	tzPicker := newTzPicker()
+	obj.(*gtk.Box).PackStart(tzPicker.box, true, true, 0)
 	obj, err = b.GetObject("installDiskCombo")
 	if err != nil {
 		panic("couldnt find installDiskCombo")
@@ -106,6 +116,53 @@ func initSettingsPane(b *gtk.Builder) *settingsPane {
 	loginCheck := obj.(*gtk.CheckButton)
 	// loginCheck.Connect("toggled", mw.callbackSettingsTyped)
 
+	obj, err = b.GetObject("sshEnableCheck")
+	if err != nil {
+		panic("couldnt find sshEnableCheck")
+	}
+	sshCheck := obj.(*gtk.CheckButton)
+	obj, err = b.GetObject("sshPasswordAuthCheck")
+	if err != nil {
+		panic("couldnt find sshPasswordAuthCheck")
+	}
+	sshPwCheck := obj.(*gtk.CheckButton)
+	obj, err = b.GetObject("sshPortSpin")
+	if err != nil {
+		panic("couldnt find sshPortSpin")
+	}
+	sshPort := obj.(*gtk.SpinButton)
+	obj, err = b.GetObject("sshKeysInput")
+	if err != nil {
+		panic("couldnt find sshKeysInput")
+	}
+	sshKeys := obj.(*gtk.Entry)
+	obj, err = b.GetObject("sshKeysLabel")
+	if err != nil {
+		panic("couldnt find sshKeysLabel")
+	}
+	sshKeysLabel := obj.(*gtk.Label)
+
+	obj, err = b.GetObject("offlineCheck")
+	if err != nil {
+		panic("couldnt find offlineCheck")
+	}
+	offlineCheck := obj.(*gtk.CheckButton)
+	obj, err = b.GetObject("offlineCacheInput")
+	if err != nil {
+		panic("couldnt find offlineCacheInput")
+	}
+	offlineCache := obj.(*gtk.Entry)
+	obj, err = b.GetObject("offlineCacheLabel")
+	if err != nil {
+		panic("couldnt find offlineCacheLabel")
+	}
+	offlineCacheLabel := obj.(*gtk.Label)
+	obj, err = b.GetObject("flakeCheck")
+	if err != nil {
+		panic("couldnt find flakeCheck")
+	}
+	flakeCheck := obj.(*gtk.CheckButton)
+
 	disks, err := getDiskInfo()
 	if err != nil {
 		panic(err)
@@ -115,28 +172,57 @@ func initSettingsPane(b *gtk.Builder) *settingsPane {
 	}
 	diskCtrl.SetActive(0)
 
-	tzs := timezones()
-	for _, tz := range tzs {
-		tzCtrl.Append(tz, tz)
-	}
-	tzCtrl.SetActive(0)
-
 	p := &settingsPane{
 		content,
 		hostCtrl, userCtrl,
-		tzCtrl, diskCtrl,
+		tzPicker, diskCtrl,
 		pwCtrl, pwConfirm, pwLabel,
 		hostLabel, userLabel,
 		scrubCheck, loginCheck,
+		sshCheck, sshPwCheck,
+		sshPort, sshKeys, sshKeysLabel, "",
+		offlineCheck, offlineCache, offlineCacheLabel,
+		flakeCheck,
 		disks,
 	}
 	pwCtrl.Connect("changed", p.callbackPwChanged)
 	pwConfirm.Connect("changed", p.callbackPwChanged)
 	hostCtrl.Connect("changed", p.callbackHostChanged)
 	userCtrl.Connect("changed", p.callbackUserChanged)
+	p.sshKeysHelp, _ = sshKeysLabel.GetText()
+	sshCheck.Connect("toggled", p.callbackSSHToggled)
+	p.callbackSSHToggled()
+	offlineCheck.Connect("toggled", p.callbackOfflineToggled)
+	p.callbackOfflineToggled()
 	return p
 }
 
+func (p *settingsPane) callbackOfflineToggled() {
+	p.offlineCache.SetSensitive(p.offlineCheck.GetActive())
+}
+
+func (p *settingsPane) callbackSSHToggled() {
+	enabled := p.sshCheck.GetActive()
+	p.sshPort.SetSensitive(enabled)
+	p.sshPwCheck.SetSensitive(enabled)
+}
+
+// authorizedKeys interprets the keys input, which holds either a key, the
+// path to a file of keys, or such a path on a USB drive prefixed by usb:.
+func (p *settingsPane) authorizedKeys() install.AuthorizedKeys {
+	in, _ := p.sshKeys.GetText()
+	in = strings.TrimSpace(in)
+	switch {
+	case in == "":
+		return install.AuthorizedKeys{}
+	case strings.HasPrefix(in, "usb:"):
+		return install.AuthorizedKeys{USBFile: strings.TrimPrefix(in, "usb:")}
+	case strings.HasPrefix(in, "/"):
+		return install.AuthorizedKeys{File: in}
+	}
+	return install.AuthorizedKeys{Keys: []string{in}}
+}
+
 func (p *settingsPane) callbackPwChanged() {
 	mainPw, _ := p.pwCtrl.GetText()
 	confPw, _ := p.pwConfirm.GetText()
@@ -156,7 +242,7 @@ func (p *settingsPane) callbackHostChanged() {
 	h, _ := p.hostCtrl.GetText()
 
 	sc, _ := p.hostLabel.GetStyleContext()
-	if !strings.ContainsAny(h, "!@#$%^&*()=+[]{}~`\\| ?,./<>") && h != "" {
+	if install.ValidateHostname(h) == nil {
 		sc.AddClass("validPassword")
 		sc.RemoveClass("invalidPassword")
 	} else {
@@ -207,15 +293,40 @@ func (p *settingsPane) ShouldNext(settings *install.Settings, fullGrid *gtk.Grid
 		return false, nil
 	}
 	h, _ := p.hostCtrl.GetText()
-	if strings.ContainsAny(h, "!@#$%^&*()=+[]{}~`\\| ?,./<>") || h == "" {
+	if install.ValidateHostname(h) != nil {
 		return false, nil
 	}
 	u, _ := p.userCtrl.GetText()
 	if strings.ContainsAny(u, "!@#$%^&*()=+[]{}~`\\| ?,./<>") || u == "" {
 		return false, nil
 	}
+	keys := p.authorizedKeys()
+	sc, _ := p.sshKeysLabel.GetStyleContext()
+	if _, err := keys.Resolve(); err != nil {
+		p.sshKeysLabel.SetText(err.Error())
+		sc.AddClass("invalidPassword")
+		return false, nil
+	}
+	p.sshKeysLabel.SetText(p.sshKeysHelp)
+	sc.RemoveClass("invalidPassword")
+	cache, _ := p.offlineCache.GetText()
+	offline := install.OfflineSettings{
+		Enable: p.offlineCheck.GetActive(),
+		Cache:  strings.TrimSpace(cache),
+	}
+	sc, _ = p.offlineCacheLabel.GetStyleContext()
+	if err := offline.Validate(); err != nil {
+		p.offlineCacheLabel.SetText(err.Error())
+		sc.AddClass("invalidPassword")
+		return false, nil
+	}
+	p.offlineCacheLabel.SetText("")
+	sc.RemoveClass("invalidPassword")
 	disk := p.disks[p.diskCtrl.GetActive()]
-	tz := p.tzCtrl.GetActiveText()
+	tz := p.tzPicker.Selected()
+	if tz == "" {
+		return false, nil
+	}
 
 	// Otherwise lets populate the settings struct!
 	settings.Hostname = h
@@ -225,56 +336,14 @@ func (p *settingsPane) ShouldNext(settings *install.Settings, fullGrid *gtk.Grid
 	settings.Timezone = tz
 	settings.Scrub = p.scrubCheck.GetActive()
 	settings.Autologin = p.loginCheck.GetActive()
-
-	return true, nil
-}
-
-func readTzDir(p string) []string {
-	var timezones []string
-	fs, _ := ioutil.ReadDir(p)
-	for _, f := range fs {
-		if strings.HasPrefix(f.Name(), "posix") || strings.HasPrefix(f.Name(), "right") || !unicode.Is(unicode.Upper, rune(f.Name()[0])) {
-			continue
-		}
-
-		if f.IsDir() {
-			readTzDir(path.Join(p, f.Name()))
-		} else {
-			timezones = append(timezones, path.Join(p, f.Name()))
-		}
+	settings.AuthorizedKeys = keys
+	settings.SSH = install.SSHSettings{
+		Enable:       p.sshCheck.GetActive(),
+		Port:         p.sshPort.GetValueAsInt(),
+		PasswordAuth: p.sshPwCheck.GetActive(),
 	}
+	settings.Offline = offline
+	settings.Flake = p.flakeCheck.GetActive()
 
-	return timezones
-}
-
-func timezones() []string {
-	// Combo boxes are messed up rn.
-	return []string{"America/Los_Angeles", "America/New_York", "Asia/Tokyo", "Australia/Sydney", "Europe/Amsterdam", "Europe/Berlin"}
-
-	// for _, base := range []string{"/usr/share/zoneinfo", "/etc/zoneinfo"} {
-	// 	if _, err := os.Stat(base); err != nil {
-	// 		continue
-	// 	}
-	//
-	// 	var timezones []string
-	// 	fs, _ := ioutil.ReadDir(base)
-	// 	for _, f := range fs {
-	// 		if strings.HasPrefix(f.Name(), "posix") || strings.HasPrefix(f.Name(), "right") || strings.HasPrefix(f.Name(), "Etc") || !unicode.Is(unicode.Upper, rune(f.Name()[0])) {
-	// 			continue
-	// 		}
-	//
-	// 		if f.IsDir() {
-	// 			timezones = append(timezones, readTzDir(path.Join(base, f.Name()))...)
-	// 		} else {
-	// 			timezones = append(timezones, path.Join(base, f.Name()))
-	// 		}
-	// 	}
-	//
-	// 	for i := range timezones {
-	// 		timezones[i] = strings.TrimPrefix(timezones[i], base+"/")
-	// 	}
-	// 	return timezones
-	// }
-	//
-	// return nil
+	return true, nil
 }
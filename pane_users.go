@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/twitchylinux/twlinst/install"
+)
+
+// Columns of the user list store.
+const (
+	userColName = iota
+	userColFullName
+	userColAdmin
+	userColShell
+)
+
+// usersPane manages the additional user accounts. Its widgets are built
+// in code rather than from layout.glade.
+type usersPane struct {
+	content   *gtk.Grid
+	listView  *gtk.TreeView
+	listStore *gtk.ListStore
+
+	nameCtrl, fullNameCtrl *gtk.Entry
+	pwCtrl, pwConfirm      *gtk.Entry
+	groupsCtrl, shellCtrl  *gtk.Entry
+	adminCheck             *gtk.CheckButton
+	errLabel               *gtk.Label
+
+	users []install.User
+}
+
+// attachFormRow attaches a label and the widget it describes to a row of
+// a grid with three columns.
+func attachFormRow(grid *gtk.Grid, row int, label string, w gtk.IWidget) {
+	l, err := gtk.LabelNew(label)
+	if err != nil {
+		panic(err)
+	}
+	l.SetXAlign(1)
+	grid.Attach(l, 0, row, 1, 1)
+	grid.Attach(w, 1, row, 2, 1)
+}
+
+func newFormEntry(grid *gtk.Grid, row int, label, placeholder string) *gtk.Entry {
+	e, err := gtk.EntryNew()
+	if err != nil {
+		panic(err)
+	}
+	e.SetHExpand(true)
+	e.SetPlaceholderText(placeholder)
+	attachFormRow(grid, row, label, e)
+	return e
+}
+
+func initUsersPane(b *gtk.Builder) *usersPane {
+	content, err := gtk.GridNew()
+	if err != nil {
+		panic(fmt.Errorf("creating grid: %w", err))
+	}
+	content.SetSizeRequest(550, 375)
+	content.SetHExpand(true)
+	content.SetVExpand(true)
+	content.SetRowSpacing(4)
+	content.SetColumnSpacing(8)
+
+	intro, err := gtk.LabelNew("Add any other people who will use this computer. The user from the previous page is the administrator.")
+	if err != nil {
+		panic(err)
+	}
+	intro.SetLineWrap(true)
+	intro.SetMarginBottom(8)
+	content.Attach(intro, 0, 0, 3, 1)
+
+	listStore, err := gtk.ListStoreNew(glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING)
+	if err != nil {
+		panic(fmt.Errorf("creating list store: %w", err))
+	}
+	listView, err := gtk.TreeViewNewWithModel(listStore)
+	if err != nil {
+		panic(fmt.Errorf("creating treeview: %w", err))
+	}
+	listView.SetVExpand(true)
+	listView.AppendColumn(makeColumn("Username", userColName))
+	listView.AppendColumn(makeColumn("Full name", userColFullName))
+	listView.AppendColumn(makeColumn("Admin", userColAdmin))
+	listView.AppendColumn(makeColumn("Shell", userColShell))
+	sw, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		panic(fmt.Errorf("creating scrolled window: %w", err))
+	}
+	sw.Add(listView)
+	content.Attach(sw, 0, 1, 3, 1)
+
+	p := &usersPane{
+		content:   content,
+		listView:  listView,
+		listStore: listStore,
+	}
+	p.nameCtrl = newFormEntry(content, 2, "Username", "")
+	p.fullNameCtrl = newFormEntry(content, 3, "Full name", "Optional")
+	p.pwCtrl = newFormEntry(content, 4, "Password", "")
+	p.pwCtrl.SetVisibility(false)
+	p.pwConfirm = newFormEntry(content, 5, "Confirm password", "")
+	p.pwConfirm.SetVisibility(false)
+	p.groupsCtrl = newFormEntry(content, 6, "Extra groups", "Comma separated, such as docker, libvirtd")
+	p.shellCtrl = newFormEntry(content, 7, "Shell", "Default (bash), or a package such as zsh")
+
+	if p.adminCheck, err = gtk.CheckButtonNewWithLabel("Administrator (can use sudo)"); err != nil {
+		panic(err)
+	}
+	content.Attach(p.adminCheck, 1, 8, 2, 1)
+
+	if p.errLabel, err = gtk.LabelNew(""); err != nil {
+		panic(err)
+	}
+	if sc, err := p.errLabel.GetStyleContext(); err == nil {
+		sc.AddClass("invalidPassword")
+	}
+	content.Attach(p.errLabel, 0, 9, 3, 1)
+
+	saveBtn, err := gtk.ButtonNewWithLabel("Add / update user")
+	if err != nil {
+		panic(err)
+	}
+	removeBtn, err := gtk.ButtonNewWithLabel("Remove user")
+	if err != nil {
+		panic(err)
+	}
+	saveBtn.SetHAlign(gtk.ALIGN_END)
+	content.Attach(removeBtn, 1, 10, 1, 1)
+	content.Attach(saveBtn, 2, 10, 1, 1)
+	content.ShowAll()
+
+	sel, err := listView.GetSelection()
+	if err != nil {
+		panic(err)
+	}
+	sel.SetMode(gtk.SELECTION_SINGLE)
+	sel.Connect("changed", p.selectionChanged)
+	saveBtn.Connect("clicked", p.callbackSave)
+	removeBtn.Connect("clicked", p.callbackRemove)
+	return p
+}
+
+// selectedIndex returns the index of the selected user, or -1.
+func (p *usersPane) selectedIndex() int {
+	sel, err := p.listView.GetSelection()
+	if err != nil {
+		return -1
+	}
+	model, iter, ok := sel.GetSelected()
+	if !ok {
+		return -1
+	}
+	path, err := model.(*gtk.TreeModel).GetPath(iter)
+	if err != nil {
+		return -1
+	}
+	return path.GetIndices()[0]
+}
+
+func (p *usersPane) selectionChanged(selection *gtk.TreeSelection) {
+	idx := p.selectedIndex()
+	if idx < 0 || idx >= len(p.users) {
+		return
+	}
+	u := p.users[idx]
+	p.nameCtrl.SetText(u.Name)
+	p.fullNameCtrl.SetText(u.FullName)
+	// The existing password is kept unless a new one is entered.
+	p.pwCtrl.SetText("")
+	p.pwConfirm.SetText("")
+	p.groupsCtrl.SetText(strings.Join(u.Groups, ", "))
+	p.shellCtrl.SetText(u.Shell)
+	p.adminCheck.SetActive(u.Admin)
+	p.errLabel.SetText("")
+}
+
+func (p *usersPane) clearForm() {
+	for _, e := range []*gtk.Entry{p.nameCtrl, p.fullNameCtrl, p.pwCtrl, p.pwConfirm, p.groupsCtrl, p.shellCtrl} {
+		e.SetText("")
+	}
+	p.adminCheck.SetActive(false)
+}
+
+func (p *usersPane) callbackSave() {
+	name, _ := p.nameCtrl.GetText()
+	fullName, _ := p.fullNameCtrl.GetText()
+	pw, _ := p.pwCtrl.GetText()
+	confPw, _ := p.pwConfirm.GetText()
+	groups, _ := p.groupsCtrl.GetText()
+	shell, _ := p.shellCtrl.GetText()
+
+	u := install.User{
+		Name:     strings.TrimSpace(name),
+		FullName: strings.TrimSpace(fullName),
+		Password: pw,
+		Admin:    p.adminCheck.GetActive(),
+		Shell:    strings.TrimSpace(shell),
+	}
+	for _, g := range strings.Split(groups, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			u.Groups = append(u.Groups, g)
+		}
+	}
+
+	if pw != confPw {
+		p.errLabel.SetText("The passwords do not match.")
+		return
+	}
+	existing := -1
+	for i := range p.users {
+		if p.users[i].Name == u.Name {
+			existing = i
+		}
+	}
+	if existing >= 0 && u.Password == "" {
+		u.Password = p.users[existing].Password
+	}
+	if err := u.Validate(); err != nil {
+		p.errLabel.SetText(err.Error())
+		return
+	}
+
+	if existing >= 0 {
+		p.users[existing] = u
+	} else {
+		p.users = append(p.users, u)
+	}
+	p.errLabel.SetText("")
+	p.clearForm()
+	p.refreshList()
+}
+
+func (p *usersPane) callbackRemove() {
+	idx := p.selectedIndex()
+	if idx < 0 || idx >= len(p.users) {
+		return
+	}
+	p.users = append(p.users[:idx], p.users[idx+1:]...)
+	p.clearForm()
+	p.refreshList()
+}
+
+func (p *usersPane) refreshList() {
+	p.listStore.Clear()
+	for _, u := range p.users {
+		admin := ""
+		if u.Admin {
+			admin = "Yes"
+		}
+		if err := p.listStore.Set(p.listStore.Append(),
+			[]int{userColName, userColFullName, userColAdmin, userColShell},
+			[]interface{}{u.Name, u.FullName, admin, u.Shell}); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (p *usersPane) Show(settings *install.Settings, fullGrid *gtk.Grid) error {
+	fullGrid.Attach(p.content, 0, 1, 1, 1)
+	return nil
+}
+
+func (p *usersPane) Hide(settings *install.Settings, fullGrid *gtk.Grid) error {
+	currentPane, err := fullGrid.GetChildAt(0, 1)
+	if err != nil {
+		return fmt.Errorf("Failed to get current pane: %v", err)
+	}
+	fullGrid.Remove(currentPane)
+	return nil
+}
+
+func (p *usersPane) ShouldNext(settings *install.Settings, fullGrid *gtk.Grid) (bool, error) {
+	settings.Users = append([]install.User(nil), p.users...)
+	if err := settings.ValidateUsers(); err != nil {
+		p.errLabel.SetText(err.Error())
+		return false, nil
+	}
+	p.errLabel.SetText("")
+	return true, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/twitchylinux/twlinst/z"
+)
+
+// Columns of the timezone tree store.
+const (
+	tzColText = iota
+	// tzColName holds the zone name, or the empty string for region rows.
+	tzColName
+	tzColSearch
+)
+
+// tzPicker is a searchable list of timezones, grouped by region.
+type tzPicker struct {
+	box       *gtk.Box
+	treeView  *gtk.TreeView
+	treeStore *gtk.TreeStore
+	filter    *gtk.TreeModelFilter
+	label     *gtk.Label
+
+	query    string
+	selected string
+	rows     map[string]*gtk.TreeIter
+}
+
+func newTzPicker() *tzPicker {
+	box, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 4)
+	if err != nil {
+		panic(fmt.Errorf("creating box: %w", err))
+	}
+	box.SetHExpand(true)
+
+	searchEntry, err := gtk.SearchEntryNew()
+	if err != nil {
+		panic(fmt.Errorf("creating search entry: %w", err))
+	}
+	searchEntry.SetPlaceholderText("Search by city, country or region")
+
+	treeView, err := gtk.TreeViewNew()
+	if err != nil {
+		panic(fmt.Errorf("creating treeview: %w", err))
+	}
+	treeView.SetHeadersVisible(false)
+	treeView.AppendColumn(makeColumn("Timezone", tzColText))
+
+	treeStore, err := gtk.TreeStoreNew(glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING)
+	if err != nil {
+		panic(fmt.Errorf("creating tree store: %w", err))
+	}
+	filter, err := treeStore.FilterNew(nil)
+	if err != nil {
+		panic(fmt.Errorf("creating tree filter: %w", err))
+	}
+	treeView.SetModel(filter)
+
+	sw, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		panic(fmt.Errorf("creating scrolled window: %w", err))
+	}
+	sw.SetSizeRequest(-1, 120)
+	sw.SetShadowType(gtk.SHADOW_IN)
+	sw.Add(treeView)
+
+	label, err := gtk.LabelNew("")
+	if err != nil {
+		panic(err)
+	}
+	label.SetXAlign(0)
+
+	box.PackStart(searchEntry, false, false, 0)
+	box.PackStart(sw, true, true, 0)
+	box.PackStart(label, false, false, 0)
+	box.ShowAll()
+
+	p := &tzPicker{
+		box:       box,
+		treeView:  treeView,
+		treeStore: treeStore,
+		filter:    filter,
+		label:     label,
+		rows:      map[string]*gtk.TreeIter{},
+	}
+	filter.SetVisibleFunc(p.rowVisible)
+	searchEntry.Connect("search-changed", p.searchChanged)
+	sel, err := treeView.GetSelection()
+	if err != nil {
+		panic(err)
+	}
+	sel.SetMode(gtk.SELECTION_SINGLE)
+	sel.Connect("changed", p.selectionChanged)
+
+	zones, err := z.ReadTimezones()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Reading timezones: %v\n", err)
+		zones = []z.Timezone{{Name: "UTC"}}
+	}
+	// The live system may use a legacy alias omitted from the list.
+	current := z.CurrentTimezone()
+	if z.FindTimezone(zones, current) == nil {
+		if ok, _ := z.IsTimezone(current); ok {
+			zones = append(zones, z.Timezone{Name: current})
+		} else {
+			current = "UTC"
+		}
+	}
+	p.populate(zones)
+	p.Select(current)
+	return p
+}
+
+func (p *tzPicker) populate(zones []z.Timezone) {
+	// Region rows match the filter if any of their zones do.
+	regionSearch := map[string][]string{}
+	var regions []string
+	for i := range zones {
+		tz := &zones[i]
+		if !strings.Contains(tz.Name, "/") {
+			continue
+		}
+		if _, ok := regionSearch[tz.Region()]; !ok {
+			regions = append(regions, tz.Region())
+		}
+		regionSearch[tz.Region()] = append(regionSearch[tz.Region()], tzSearchText(tz))
+	}
+
+	regionRows := map[string]*gtk.TreeIter{}
+	for _, r := range regions {
+		regionRows[r] = p.appendRow(nil, r, "", r+" "+strings.Join(regionSearch[r], " "))
+	}
+	for i := range zones {
+		tz := &zones[i]
+		text := tz.City()
+		if len(tz.Countries) > 0 {
+			text += " — " + strings.Join(tz.Countries, ", ")
+		}
+		if tz.Comment != "" {
+			text += " (" + tz.Comment + ")"
+		}
+		p.rows[tz.Name] = p.appendRow(regionRows[tz.Region()], text, tz.Name, tzSearchText(tz))
+	}
+}
+
+func tzSearchText(tz *z.Timezone) string {
+	return strings.Join(append([]string{tz.Name, tz.City(), tz.Comment}, tz.Countries...), " ")
+}
+
+func (p *tzPicker) appendRow(maybeParent *gtk.TreeIter, text, name, search string) *gtk.TreeIter {
+	i := p.treeStore.Append(maybeParent)
+	for col, v := range []interface{}{text, name, search} {
+		if err := p.treeStore.SetValue(i, col, v); err != nil {
+			panic(err)
+		}
+	}
+	return i
+}
+
+// Select selects the named zone, expanding its region and scrolling to it.
+func (p *tzPicker) Select(name string) {
+	iter, ok := p.rows[name]
+	if !ok {
+		return
+	}
+	p.selected = name
+	p.label.SetText("Selected: " + name)
+
+	childPath, err := p.treeStore.GetPath(iter)
+	if err != nil {
+		return
+	}
+	path := p.filter.ConvertChildPathToPath(childPath)
+	if path == nil {
+		return
+	}
+	p.treeView.ExpandToPath(path)
+	if sel, err := p.treeView.GetSelection(); err == nil {
+		sel.SelectPath(path)
+	}
+	p.treeView.ScrollToCell(path, nil, true, 0.5, 0)
+}
+
+// Selected returns the name of the selected zone. The selection is kept
+// while the search hides it.
+func (p *tzPicker) Selected() string {
+	return p.selected
+}
+
+func (p *tzPicker) selectionChanged(selection *gtk.TreeSelection) {
+	model, iter, ok := selection.GetSelected()
+	if !ok {
+		return
+	}
+	v, err := model.(*gtk.TreeModel).GetValue(iter, tzColName)
+	if err != nil {
+		return
+	}
+	if name, _ := v.GetString(); name != "" {
+		p.selected = name
+		p.label.SetText("Selected: " + name)
+	}
+}
+
+func (p *tzPicker) rowVisible(model *gtk.TreeModel, iter *gtk.TreeIter) bool {
+	if p.query == "" {
+		return true
+	}
+	v, err := model.GetValue(iter, tzColSearch)
+	if err != nil {
+		return true
+	}
+	search, _ := v.GetString()
+	return strings.Contains(strings.ToLower(search), p.query)
+}
+
+func (p *tzPicker) searchChanged(entry *gtk.SearchEntry) {
+	text, err := entry.GetText()
+	if err != nil {
+		return
+	}
+	p.query = strings.ToLower(strings.TrimSpace(text))
+	p.filter.Refilter()
+	if p.query != "" {
+		p.treeView.ExpandAll()
+	} else {
+		p.Select(p.selected)
+	}
+}
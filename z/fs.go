@@ -122,3 +122,20 @@ func GetUdevDiskInfo(path string, isRoot bool) (*Disk, error) {
 
 	return &out, nil
 }
+
+// IsMounted returns true if a filesystem is mounted at the given path.
+func IsMounted(mountpoint string) (bool, error) {
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if fields := strings.Fields(s.Text()); len(fields) > 1 && fields[1] == mountpoint {
+			return true, nil
+		}
+	}
+	return false, s.Err()
+}
@@ -0,0 +1,217 @@
+package z
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// HardwareInfo describes the machine, as used to pick nixos-hardware profiles.
+type HardwareInfo struct {
+	SysVendor      string
+	ProductName    string
+	ProductVersion string
+	BoardName      string
+
+	CPUVendor  string   // One of "intel", "amd", or empty.
+	GPUVendors []string // Any of "intel", "amd", "nvidia".
+}
+
+var pciVendors = map[string]string{
+	"0x8086": "intel",
+	"0x1002": "amd",
+	"0x1022": "amd",
+	"0x10de": "nvidia",
+}
+
+func readSysfsString(path string) string {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(d))
+}
+
+// ReadHardwareInfo reads DMI data, CPU and GPU vendors from the running
+// system. Information which is not available is left empty.
+func ReadHardwareInfo() (*HardwareInfo, error) {
+	const dmiBase = "/sys/class/dmi/id"
+	out := HardwareInfo{
+		SysVendor:      readSysfsString(filepath.Join(dmiBase, "sys_vendor")),
+		ProductName:    readSysfsString(filepath.Join(dmiBase, "product_name")),
+		ProductVersion: readSysfsString(filepath.Join(dmiBase, "product_version")),
+		BoardName:      readSysfsString(filepath.Join(dmiBase, "board_name")),
+	}
+
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if !strings.HasPrefix(s.Text(), "vendor_id") {
+			continue
+		}
+		switch {
+		case strings.Contains(s.Text(), "GenuineIntel"):
+			out.CPUVendor = "intel"
+		case strings.Contains(s.Text(), "AuthenticAMD"):
+			out.CPUVendor = "amd"
+		}
+		break
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	devices, _ := filepath.Glob("/sys/bus/pci/devices/*")
+	seen := map[string]bool{}
+	for _, dev := range devices {
+		// Class 0x03xxxx covers VGA, 3D and other display controllers.
+		if !strings.HasPrefix(readSysfsString(filepath.Join(dev, "class")), "0x03") {
+			continue
+		}
+		if v, ok := pciVendors[readSysfsString(filepath.Join(dev, "vendor"))]; ok && !seen[v] {
+			seen[v] = true
+			out.GPUVendors = append(out.GPUVendors, v)
+		}
+	}
+	sort.Strings(out.GPUVendors)
+
+	return &out, nil
+}
+
+// HardwareMatch is a rule matching a machine to a nixos-hardware profile.
+// Every non-empty field must match the machine, case-insensitively, as
+// a glob pattern.
+type HardwareMatch struct {
+	SysVendor      string `json:"sys_vendor"`
+	ProductName    string `json:"product_name"`
+	ProductVersion string `json:"product_version"`
+	BoardName      string `json:"board_name"`
+	CPUVendor      string `json:"cpu"`
+	GPUVendor      string `json:"gpu"`
+}
+
+func globMatch(pattern, value string) bool {
+	ok, err := filepath.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return err == nil && ok
+}
+
+// Matches returns true if the rule matches the machine.
+func (m HardwareMatch) Matches(info *HardwareInfo) bool {
+	for _, f := range []struct{ pattern, value string }{
+		{m.SysVendor, info.SysVendor},
+		{m.ProductName, info.ProductName},
+		{m.ProductVersion, info.ProductVersion},
+		{m.BoardName, info.BoardName},
+		{m.CPUVendor, info.CPUVendor},
+	} {
+		if f.pattern != "" && !globMatch(f.pattern, f.value) {
+			return false
+		}
+	}
+
+	if m.GPUVendor != "" {
+		for _, v := range info.GPUVendors {
+			if globMatch(m.GPUVendor, v) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// HardwareCandidate is a nixos-hardware profile which matches the machine.
+type HardwareCandidate struct {
+	Profile string
+	// Score ranks how well the profile matches, higher being better.
+	Score float64
+}
+
+const (
+	// ruleScore is the score of profiles matched by an explicit rule.
+	ruleScore = 100
+	// commonScore is the score of the generic common-cpu/gpu profiles.
+	commonScore = 0.5
+	// minWordFraction is the fraction of the model words in a profile name
+	// which must be present in the DMI data for it to match.
+	minWordFraction = 0.6
+)
+
+func words(s string) map[string]bool {
+	out := map[string]bool{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}) {
+		out[w] = true
+	}
+	return out
+}
+
+// MatchHardwareProfiles returns the profiles which match the machine,
+// best match first. Profiles with explicit match rules are matched using
+// those rules only; others are matched on their name, in the form used by
+// nixos-hardware: "<vendor>-<model words>", "common-cpu-<vendor>" or
+// "common-gpu-<vendor>".
+func MatchHardwareProfiles(info *HardwareInfo, profiles []string, rules map[string][]HardwareMatch) []HardwareCandidate {
+	var (
+		out    []HardwareCandidate
+		vendor = words(info.SysVendor)
+		model  = words(strings.Join([]string{info.ProductName, info.ProductVersion, info.BoardName}, " "))
+	)
+
+	for _, profile := range profiles {
+		if r, hasRules := rules[profile]; hasRules {
+			for _, rule := range r {
+				if rule.Matches(info) {
+					out = append(out, HardwareCandidate{Profile: profile, Score: ruleScore})
+					break
+				}
+			}
+			continue
+		}
+
+		parts := strings.Split(strings.ToLower(profile), "-")
+		if len(parts) < 2 {
+			continue
+		}
+		switch {
+		case len(parts) == 3 && parts[0] == "common" && parts[1] == "cpu":
+			if parts[2] == info.CPUVendor {
+				out = append(out, HardwareCandidate{Profile: profile, Score: commonScore})
+			}
+		case len(parts) == 3 && parts[0] == "common" && parts[1] == "gpu":
+			for _, v := range info.GPUVendors {
+				if parts[2] == v {
+					out = append(out, HardwareCandidate{Profile: profile, Score: commonScore})
+				}
+			}
+		case vendor[parts[0]] || strings.HasPrefix(strings.ToLower(info.SysVendor), parts[0]):
+			matched := 0
+			for _, w := range parts[1:] {
+				if model[w] {
+					matched++
+				}
+			}
+			// Weight by the number of matched words, so specific profiles
+			// rank above more generic ones for the same vendor.
+			if frac := float64(matched) / float64(len(parts)-1); frac >= minWordFraction {
+				out = append(out, HardwareCandidate{Profile: profile, Score: frac * float64(matched)})
+			}
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Profile < out[j].Profile
+	})
+	return out
+}
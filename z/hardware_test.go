@@ -0,0 +1,68 @@
+package z
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchHardwareProfiles(t *testing.T) {
+	profiles := []string{
+		"lenovo-thinkpad",
+		"lenovo-thinkpad-x1-6th-gen",
+		"lenovo-thinkpad-x1-7th-gen",
+		"lenovo-thinkpad-t480s",
+		"dell-xps-13-9370",
+		"common-cpu-intel",
+		"common-cpu-amd",
+		"common-gpu-nvidia",
+		"framework",
+		"purism-librem-13v3",
+	}
+	x1 := &HardwareInfo{
+		SysVendor:      "LENOVO",
+		ProductName:    "20KHCTO1WW",
+		ProductVersion: "ThinkPad X1 Carbon 6th",
+		BoardName:      "20KHCTO1WW",
+		CPUVendor:      "intel",
+		GPUVendors:     []string{"intel", "nvidia"},
+	}
+
+	tcs := []struct {
+		name  string
+		info  *HardwareInfo
+		rules map[string][]HardwareMatch
+		want  []string
+	}{
+		{
+			name: "heuristic",
+			info: x1,
+			want: []string{"lenovo-thinkpad-x1-6th-gen", "lenovo-thinkpad", "common-cpu-intel", "common-gpu-nvidia"},
+		},
+		{
+			name: "rules",
+			info: x1,
+			rules: map[string][]HardwareMatch{
+				"lenovo-thinkpad-x1-6th-gen": {{SysVendor: "lenovo", ProductVersion: "*X1 Carbon 7th*"}},
+				"purism-librem-13v3":         {{SysVendor: "purism"}, {ProductName: "20KH*"}},
+			},
+			want: []string{"purism-librem-13v3", "lenovo-thinkpad", "common-cpu-intel", "common-gpu-nvidia"},
+		},
+		{
+			name: "no dmi",
+			info: &HardwareInfo{CPUVendor: "amd"},
+			want: []string{"common-cpu-amd"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, c := range MatchHardwareProfiles(tc.info, profiles, tc.rules) {
+				got = append(got, c.Profile)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package z
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// localePaths are searched in order for the list of supported locales.
+var localePaths = []string{
+	"/run/current-system/sw/share/i18n/SUPPORTED",
+	"/usr/share/i18n/SUPPORTED",
+	"/etc/locale.gen",
+}
+
+// DefaultLocale is the locale used if none is chosen.
+const DefaultLocale = "en_US.UTF-8"
+
+// parseLocales returns the UTF-8 locales listed in glibc's SUPPORTED file
+// or /etc/locale.gen, where they may be commented out. Lines look like
+// "en_US.UTF-8/UTF-8 \" or "# en_US.UTF-8 UTF-8".
+func parseLocales(r io.Reader) ([]string, error) {
+	var (
+		out  []string
+		seen = map[string]bool{}
+	)
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s.Text()), "\\"))
+		line = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+		fields := strings.Fields(strings.Replace(line, "/", " ", 1))
+		if len(fields) != 2 || fields[1] != "UTF-8" {
+			continue
+		}
+		// Skip commentary which happens to have two words.
+		if !strings.Contains(fields[0], "_") && fields[0] != "C.UTF-8" {
+			continue
+		}
+		if !seen[fields[0]] {
+			seen[fields[0]] = true
+			out = append(out, fields[0])
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// ReadLocales returns the UTF-8 locales supported by the system.
+func ReadLocales() ([]string, error) {
+	for _, p := range localePaths {
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		locales, err := parseLocales(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", p, err)
+		}
+		if len(locales) > 0 {
+			return locales, nil
+		}
+	}
+	return nil, fmt.Errorf("no list of locales found, tried %s", strings.Join(localePaths, ", "))
+}
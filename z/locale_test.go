@@ -0,0 +1,37 @@
+package z
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLocales(t *testing.T) {
+	tcs := []struct {
+		name, in string
+		want     []string
+	}{
+		{
+			"SUPPORTED",
+			"SUPPORTED-LOCALES=\\\nen_US.UTF-8/UTF-8 \\\nen_US/ISO-8859-1 \\\nde_DE.UTF-8/UTF-8 \\\nC.UTF-8/UTF-8 \\\n",
+			[]string{"C.UTF-8", "de_DE.UTF-8", "en_US.UTF-8"},
+		},
+		{
+			"locale.gen",
+			"# This file lists locales\n#  <locale> <charset>\n# en_GB.UTF-8 UTF-8\nen_US.UTF-8 UTF-8\n# fr_FR ISO-8859-1\n",
+			[]string{"en_GB.UTF-8", "en_US.UTF-8"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseLocales(strings.NewReader(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseLocales() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package z
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// NetworkConnectionsDir holds NetworkManager's persistent connection
+// profiles.
+const NetworkConnectionsDir = "/etc/NetworkManager/system-connections"
+
+// NetworkManager D-Bus names.
+const (
+	nmService         = "org.freedesktop.NetworkManager"
+	nmPath            = "/org/freedesktop/NetworkManager"
+	nmIface           = "org.freedesktop.NetworkManager"
+	nmDeviceIface     = nmIface + ".Device"
+	nmWirelessIface   = nmIface + ".Device.Wireless"
+	nmAPIface         = nmIface + ".AccessPoint"
+	nmSettingsPath    = nmPath + "/Settings"
+	nmSettingsIface   = nmIface + ".Settings"
+	nmConnectionIface = nmIface + ".Settings.Connection"
+)
+
+// NetDeviceType is the kind of a network device.
+type NetDeviceType uint32
+
+// Device types we handle. NetworkManager reports many more.
+const (
+	NetDeviceEthernet NetDeviceType = 1
+	NetDeviceWifi     NetDeviceType = 2
+)
+
+func (t NetDeviceType) String() string {
+	switch t {
+	case NetDeviceEthernet:
+		return "Wired"
+	case NetDeviceWifi:
+		return "Wi-Fi"
+	}
+	return fmt.Sprintf("Type %d", uint32(t))
+}
+
+// NetDeviceState is the state of a network device, as NMDeviceState.
+type NetDeviceState uint32
+
+// Device states of interest.
+const (
+	NetDeviceUnavailable  NetDeviceState = 20
+	NetDeviceDisconnected NetDeviceState = 30
+	NetDeviceActivated    NetDeviceState = 100
+	NetDeviceFailed       NetDeviceState = 120
+)
+
+func (s NetDeviceState) String() string {
+	switch {
+	case s < NetDeviceUnavailable:
+		return "Unmanaged"
+	case s == NetDeviceUnavailable:
+		return "Unavailable"
+	case s == NetDeviceDisconnected:
+		return "Disconnected"
+	case s < NetDeviceActivated:
+		return "Connecting"
+	case s == NetDeviceActivated:
+		return "Connected"
+	case s == NetDeviceFailed:
+		return "Failed"
+	}
+	return "Disconnecting"
+}
+
+// Connectivity is how well the system is connected to the internet, as
+// NMConnectivityState.
+type Connectivity uint32
+
+// Connectivity states.
+const (
+	ConnectivityUnknown Connectivity = iota
+	ConnectivityNone
+	ConnectivityPortal
+	ConnectivityLimited
+	ConnectivityFull
+)
+
+func (c Connectivity) String() string {
+	switch c {
+	case ConnectivityNone:
+		return "Not connected"
+	case ConnectivityPortal:
+		return "Behind a captive portal"
+	case ConnectivityLimited:
+		return "Connected, but without internet access"
+	case ConnectivityFull:
+		return "Connected to the internet"
+	}
+	return "Unknown"
+}
+
+// NetDevice is a wired or wireless network device.
+type NetDevice struct {
+	Path      dbus.ObjectPath
+	Interface string
+	Type      NetDeviceType
+	State     NetDeviceState
+	// ActiveSSID is the network a wireless device is using, if any.
+	ActiveSSID string
+}
+
+// AccessPoint is a Wi-Fi network seen by a wireless device.
+type AccessPoint struct {
+	Path dbus.ObjectPath
+	SSID string
+	// Strength is the signal quality as a percentage.
+	Strength uint8
+	Secured  bool
+}
+
+// SavedConnection is a connection profile known to NetworkManager.
+type SavedConnection struct {
+	Path     dbus.ObjectPath
+	ID, UUID string
+	Type     string
+	// Filename is the file the profile is stored in, or the empty string
+	// for profiles which only exist in memory.
+	Filename string
+}
+
+// NetworkManager is a client for the NetworkManager D-Bus API.
+type NetworkManager struct {
+	conn *dbus.Conn
+}
+
+// ConnectNetworkManager returns a client for NetworkManager on the
+// system bus.
+func ConnectNetworkManager() (*NetworkManager, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to system bus: %w", err)
+	}
+	return NewNetworkManager(conn), nil
+}
+
+// NewNetworkManager returns a client which talks to NetworkManager over
+// the given connection.
+func NewNetworkManager(conn *dbus.Conn) *NetworkManager {
+	return &NetworkManager{conn: conn}
+}
+
+// Close closes the underlying D-Bus connection.
+func (nm *NetworkManager) Close() error {
+	return nm.conn.Close()
+}
+
+func (nm *NetworkManager) object(path dbus.ObjectPath) dbus.BusObject {
+	return nm.conn.Object(nmService, path)
+}
+
+// Connectivity returns the last known connectivity state.
+func (nm *NetworkManager) Connectivity() (Connectivity, error) {
+	var c uint32
+	if err := nm.object(nmPath).StoreProperty(nmIface+".Connectivity", &c); err != nil {
+		return ConnectivityUnknown, err
+	}
+	return Connectivity(c), nil
+}
+
+// Devices returns the wired and wireless devices, ordered by interface
+// name.
+func (nm *NetworkManager) Devices() ([]NetDevice, error) {
+	var paths []dbus.ObjectPath
+	if err := nm.object(nmPath).Call(nmIface+".GetDevices", 0).Store(&paths); err != nil {
+		return nil, fmt.Errorf("listing devices: %w", err)
+	}
+
+	var out []NetDevice
+	for _, p := range paths {
+		var (
+			obj   = nm.object(p)
+			d     = NetDevice{Path: p}
+			typ   uint32
+			state uint32
+		)
+		if err := obj.StoreProperty(nmDeviceIface+".DeviceType", &typ); err != nil {
+			return nil, fmt.Errorf("reading device %s: %w", p, err)
+		}
+		if d.Type = NetDeviceType(typ); d.Type != NetDeviceEthernet && d.Type != NetDeviceWifi {
+			continue
+		}
+		if err := obj.StoreProperty(nmDeviceIface+".Interface", &d.Interface); err != nil {
+			return nil, fmt.Errorf("reading device %s: %w", p, err)
+		}
+		if err := obj.StoreProperty(nmDeviceIface+".State", &state); err != nil {
+			return nil, fmt.Errorf("reading device %s: %w", p, err)
+		}
+		d.State = NetDeviceState(state)
+		if d.Type == NetDeviceWifi {
+			var ap dbus.ObjectPath
+			if err := obj.StoreProperty(nmWirelessIface+".ActiveAccessPoint", &ap); err != nil {
+				return nil, fmt.Errorf("reading device %s: %w", p, err)
+			}
+			if ap != "/" && ap != "" {
+				var ssid []byte
+				if err := nm.object(ap).StoreProperty(nmAPIface+".Ssid", &ssid); err == nil {
+					d.ActiveSSID = string(ssid)
+				}
+			}
+		}
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Interface < out[j].Interface })
+	return out, nil
+}
+
+// RequestScan asks a wireless device to scan for networks. The results
+// arrive over the following seconds.
+func (nm *NetworkManager) RequestScan(dev NetDevice) error {
+	return nm.object(dev.Path).Call(nmWirelessIface+".RequestScan", 0, map[string]dbus.Variant{}).Err
+}
+
+// AccessPoints returns the networks seen by a wireless device, strongest
+// first. Only the strongest access point of each network is returned, and
+// hidden networks are omitted.
+func (nm *NetworkManager) AccessPoints(dev NetDevice) ([]AccessPoint, error) {
+	var paths []dbus.ObjectPath
+	if err := nm.object(dev.Path).Call(nmWirelessIface+".GetAllAccessPoints", 0).Store(&paths); err != nil {
+		return nil, fmt.Errorf("listing access points: %w", err)
+	}
+
+	bySSID := map[string]AccessPoint{}
+	for _, p := range paths {
+		var (
+			obj                       = nm.object(p)
+			ssid                      []byte
+			ap                        = AccessPoint{Path: p}
+			flags, wpaFlags, rsnFlags uint32
+		)
+		if err := obj.StoreProperty(nmAPIface+".Ssid", &ssid); err != nil {
+			return nil, fmt.Errorf("reading access point %s: %w", p, err)
+		}
+		if ap.SSID = string(ssid); ap.SSID == "" {
+			continue
+		}
+		if err := obj.StoreProperty(nmAPIface+".Strength", &ap.Strength); err != nil {
+			return nil, fmt.Errorf("reading access point %s: %w", p, err)
+		}
+		for prop, v := range map[string]*uint32{"Flags": &flags, "WpaFlags": &wpaFlags, "RsnFlags": &rsnFlags} {
+			if err := obj.StoreProperty(nmAPIface+"."+prop, v); err != nil {
+				return nil, fmt.Errorf("reading access point %s: %w", p, err)
+			}
+		}
+		ap.Secured = flags&1 != 0 || wpaFlags != 0 || rsnFlags != 0
+
+		if existing, ok := bySSID[ap.SSID]; !ok || existing.Strength < ap.Strength {
+			bySSID[ap.SSID] = ap
+		}
+	}
+
+	out := make([]AccessPoint, 0, len(bySSID))
+	for _, ap := range bySSID {
+		out = append(out, ap)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Strength != out[j].Strength {
+			return out[i].Strength > out[j].Strength
+		}
+		return out[i].SSID < out[j].SSID
+	})
+	return out, nil
+}
+
+// ConnectWifi creates a connection profile for the network and activates
+// it on the device. The passphrase is ignored for open networks.
+func (nm *NetworkManager) ConnectWifi(dev NetDevice, ap AccessPoint, passphrase string) error {
+	settings := map[string]map[string]dbus.Variant{
+		"connection": {
+			"id":   dbus.MakeVariant(ap.SSID),
+			"type": dbus.MakeVariant("802-11-wireless"),
+		},
+		"802-11-wireless": {
+			"ssid": dbus.MakeVariant([]byte(ap.SSID)),
+			"mode": dbus.MakeVariant("infrastructure"),
+		},
+	}
+	if ap.Secured {
+		if passphrase == "" {
+			return fmt.Errorf("%s needs a passphrase", ap.SSID)
+		}
+		settings["802-11-wireless-security"] = map[string]dbus.Variant{
+			"key-mgmt": dbus.MakeVariant("wpa-psk"),
+			"psk":      dbus.MakeVariant(passphrase),
+		}
+	}
+
+	var conn, active dbus.ObjectPath
+	if err := nm.object(nmPath).Call(nmIface+".AddAndActivateConnection", 0, settings, dev.Path, ap.Path).Store(&conn, &active); err != nil {
+		return fmt.Errorf("connecting to %s: %w", ap.SSID, err)
+	}
+	return nil
+}
+
+// ConnectWired activates the best available connection on a wired device.
+func (nm *NetworkManager) ConnectWired(dev NetDevice) error {
+	var active dbus.ObjectPath
+	if err := nm.object(nmPath).Call(nmIface+".ActivateConnection", 0, dbus.ObjectPath("/"), dev.Path, dbus.ObjectPath("/")).Store(&active); err != nil {
+		return fmt.Errorf("connecting %s: %w", dev.Interface, err)
+	}
+	return nil
+}
+
+// SavedConnections returns the connection profiles NetworkManager knows
+// about, ordered by ID.
+func (nm *NetworkManager) SavedConnections() ([]SavedConnection, error) {
+	var paths []dbus.ObjectPath
+	if err := nm.object(nmSettingsPath).Call(nmSettingsIface+".ListConnections", 0).Store(&paths); err != nil {
+		return nil, fmt.Errorf("listing connections: %w", err)
+	}
+
+	var out []SavedConnection
+	for _, p := range paths {
+		var (
+			obj      = nm.object(p)
+			settings map[string]map[string]dbus.Variant
+			c        = SavedConnection{Path: p}
+		)
+		if err := obj.Call(nmConnectionIface+".GetSettings", 0).Store(&settings); err != nil {
+			return nil, fmt.Errorf("reading connection %s: %w", p, err)
+		}
+		conn := settings["connection"]
+		c.ID, _ = conn["id"].Value().(string)
+		c.UUID, _ = conn["uuid"].Value().(string)
+		c.Type, _ = conn["type"].Value().(string)
+		// Filename is missing before NetworkManager 1.12.
+		if v, err := obj.GetProperty(nmConnectionIface + ".Filename"); err == nil {
+			c.Filename, _ = v.Value().(string)
+		}
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// Persistent returns true if the profile is stored in
+// NetworkConnectionsDir, and so can be copied to the installed system.
+func (c *SavedConnection) Persistent() bool {
+	return filepath.Dir(c.Filename) == NetworkConnectionsDir
+}
@@ -0,0 +1,391 @@
+package z
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// fakeSASL plays the bus side of the authentication handshake, accepting
+// anonymous logins. It reads byte at a time so nothing after BEGIN is
+// consumed.
+func fakeSASL(rw io.ReadWriter) error {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := rw.Read(b); err != nil {
+			return err
+		}
+		if b[0] == 0 {
+			continue
+		}
+		if b[0] != '\n' {
+			line = append(line, b[0])
+			continue
+		}
+
+		var reply string
+		switch cmd := strings.TrimSpace(string(line)); {
+		case cmd == "BEGIN":
+			return nil
+		case strings.HasPrefix(cmd, "AUTH ANONYMOUS"):
+			reply = "OK 0123456789abcdef0123456789abcdef\r\n"
+		case strings.HasPrefix(cmd, "AUTH"):
+			reply = "REJECTED ANONYMOUS\r\n"
+		default:
+			reply = "ERROR\r\n"
+		}
+		if _, err := io.WriteString(rw, reply); err != nil {
+			return err
+		}
+		line = line[:0]
+	}
+}
+
+// testConnPair returns two D-Bus connections which talk directly to each
+// other, standing in for a message bus.
+func testConnPair(t *testing.T) (client, server *dbus.Conn) {
+	t.Helper()
+	var (
+		conns [2]*dbus.Conn
+		ends  [2]net.Conn
+		wg    sync.WaitGroup
+		errs  = make(chan error, 4)
+	)
+	for i := range conns {
+		c, bus := net.Pipe()
+		ends[i] = bus
+		conn, err := dbus.NewConn(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = conn
+		t.Cleanup(func() { conn.Close() })
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs <- conn.Auth([]dbus.Auth{dbus.AuthAnonymous()})
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- fakeSASL(bus)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("authenticating: %v", err)
+		}
+	}
+
+	go io.Copy(ends[0], ends[1])
+	go io.Copy(ends[1], ends[0])
+	return conns[0], conns[1]
+}
+
+type fakeDevice struct {
+	iface string
+	typ   NetDeviceType
+	state NetDeviceState
+	aps   []dbus.ObjectPath
+	// active is the access point a wireless device is using.
+	active dbus.ObjectPath
+}
+
+type fakeAP struct {
+	ssid            string
+	strength        uint8
+	flags, rsnFlags uint32
+}
+
+type fakeConnection struct {
+	settings map[string]map[string]dbus.Variant
+	filename string
+}
+
+// fakeNetworkManager implements enough of the NetworkManager D-Bus API
+// to exercise the client.
+type fakeNetworkManager struct {
+	mu           sync.Mutex
+	conn         *dbus.Conn
+	connectivity Connectivity
+	devices      map[dbus.ObjectPath]*fakeDevice
+	aps          map[dbus.ObjectPath]*fakeAP
+	connections  map[dbus.ObjectPath]*fakeConnection
+	scans        int
+}
+
+func startFakeNetworkManager(t *testing.T, conn *dbus.Conn) *fakeNetworkManager {
+	t.Helper()
+	f := &fakeNetworkManager{
+		conn:         conn,
+		connectivity: ConnectivityNone,
+		devices: map[dbus.ObjectPath]*fakeDevice{
+			nmPath + "/Devices/1": {iface: "lo", typ: 14, state: NetDeviceActivated},
+			nmPath + "/Devices/2": {iface: "enp3s0", typ: NetDeviceEthernet, state: NetDeviceDisconnected},
+			nmPath + "/Devices/3": {iface: "wlp2s0", typ: NetDeviceWifi, state: NetDeviceDisconnected, aps: []dbus.ObjectPath{
+				nmPath + "/AccessPoint/1", nmPath + "/AccessPoint/2", nmPath + "/AccessPoint/3", nmPath + "/AccessPoint/4",
+			}},
+		},
+		aps: map[dbus.ObjectPath]*fakeAP{
+			nmPath + "/AccessPoint/1": {ssid: "home", strength: 40, flags: 1, rsnFlags: 0x188},
+			nmPath + "/AccessPoint/2": {ssid: "home", strength: 82, flags: 1, rsnFlags: 0x188},
+			nmPath + "/AccessPoint/3": {ssid: "cafe", strength: 55},
+			nmPath + "/AccessPoint/4": {ssid: "", strength: 90},
+		},
+		connections: map[dbus.ObjectPath]*fakeConnection{
+			nmSettingsPath + "/1": {
+				settings: map[string]map[string]dbus.Variant{"connection": {
+					"id":   dbus.MakeVariant("Wired connection 1"),
+					"uuid": dbus.MakeVariant("0b7a5f3e-1111-4a4a-9e9e-000000000001"),
+					"type": dbus.MakeVariant("802-3-ethernet"),
+				}},
+				filename: "/run/NetworkManager/system-connections/Wired connection 1.nmconnection",
+			},
+		},
+	}
+
+	export := func(path dbus.ObjectPath, iface string, methods map[string]interface{}) {
+		if err := conn.ExportMethodTable(methods, path, iface); err != nil {
+			t.Fatal(err)
+		}
+	}
+	props := func(path dbus.ObjectPath, get func(name string) (interface{}, bool)) {
+		export(path, "org.freedesktop.DBus.Properties", map[string]interface{}{
+			"Get": func(iface, name string) (dbus.Variant, *dbus.Error) {
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				v, ok := get(name)
+				if !ok {
+					return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.UnknownProperty", []interface{}{name})
+				}
+				return dbus.MakeVariant(v), nil
+			},
+		})
+	}
+
+	export(nmPath, nmIface, map[string]interface{}{
+		"GetDevices":               f.getDevices,
+		"AddAndActivateConnection": f.addAndActivate,
+		"ActivateConnection":       f.activate,
+	})
+	props(nmPath, func(name string) (interface{}, bool) {
+		return uint32(f.connectivity), name == "Connectivity"
+	})
+	for path, d := range f.devices {
+		path, d := path, d
+		props(path, func(name string) (interface{}, bool) {
+			switch name {
+			case "Interface":
+				return d.iface, true
+			case "DeviceType":
+				return uint32(d.typ), true
+			case "State":
+				return uint32(d.state), true
+			case "ActiveAccessPoint":
+				if d.active == "" {
+					return dbus.ObjectPath("/"), d.typ == NetDeviceWifi
+				}
+				return d.active, true
+			}
+			return nil, false
+		})
+		if d.typ == NetDeviceWifi {
+			export(path, nmWirelessIface, map[string]interface{}{
+				"GetAllAccessPoints": func() ([]dbus.ObjectPath, *dbus.Error) { return d.aps, nil },
+				"RequestScan": func(map[string]dbus.Variant) *dbus.Error {
+					f.mu.Lock()
+					defer f.mu.Unlock()
+					f.scans++
+					return nil
+				},
+			})
+		}
+	}
+	for path, ap := range f.aps {
+		ap := ap
+		props(path, func(name string) (interface{}, bool) {
+			switch name {
+			case "Ssid":
+				return []byte(ap.ssid), true
+			case "Strength":
+				return ap.strength, true
+			case "Flags":
+				return ap.flags, true
+			case "WpaFlags":
+				return uint32(0), true
+			case "RsnFlags":
+				return ap.rsnFlags, true
+			}
+			return nil, false
+		})
+	}
+	export(nmSettingsPath, nmSettingsIface, map[string]interface{}{
+		"ListConnections": func() ([]dbus.ObjectPath, *dbus.Error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			var out []dbus.ObjectPath
+			for p := range f.connections {
+				out = append(out, p)
+			}
+			return out, nil
+		},
+	})
+	for path := range f.connections {
+		f.exportConnection(path)
+	}
+	return f
+}
+
+func (f *fakeNetworkManager) exportConnection(path dbus.ObjectPath) {
+	c := f.connections[path]
+	f.conn.ExportMethodTable(map[string]interface{}{
+		"GetSettings": func() (map[string]map[string]dbus.Variant, *dbus.Error) { return c.settings, nil },
+	}, path, nmConnectionIface)
+	f.conn.ExportMethodTable(map[string]interface{}{
+		"Get": func(iface, name string) (dbus.Variant, *dbus.Error) {
+			if name != "Filename" {
+				return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.UnknownProperty", []interface{}{name})
+			}
+			return dbus.MakeVariant(c.filename), nil
+		},
+	}, path, "org.freedesktop.DBus.Properties")
+}
+
+func (f *fakeNetworkManager) getDevices() ([]dbus.ObjectPath, *dbus.Error) {
+	var out []dbus.ObjectPath
+	for p := range f.devices {
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func (f *fakeNetworkManager) addAndActivate(settings map[string]map[string]dbus.Variant, dev, ap dbus.ObjectPath) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d, ok := f.devices[dev]
+	if !ok || f.aps[ap] == nil {
+		return "", "", dbus.NewError("org.freedesktop.NetworkManager.UnknownDevice", []interface{}{"no such device"})
+	}
+	if psk, ok := settings["802-11-wireless-security"]["psk"]; ok && psk.Value() != "hunter22" {
+		d.state = NetDeviceFailed
+		return "", "", dbus.NewError("org.freedesktop.NetworkManager.Failed", []interface{}{"bad passphrase"})
+	}
+	d.state = NetDeviceActivated
+	d.active = ap
+	f.connectivity = ConnectivityFull
+
+	id := settings["connection"]["id"].Value().(string)
+	settings["connection"]["uuid"] = dbus.MakeVariant("0b7a5f3e-1111-4a4a-9e9e-000000000002")
+	path := dbus.ObjectPath(fmt.Sprintf("%s/%d", nmSettingsPath, len(f.connections)+1))
+	f.connections[path] = &fakeConnection{
+		settings: settings,
+		filename: "/etc/NetworkManager/system-connections/" + id + ".nmconnection",
+	}
+	f.exportConnection(path)
+	return path, nmPath + "/ActiveConnection/1", nil
+}
+
+func (f *fakeNetworkManager) activate(conn, dev, specific dbus.ObjectPath) (dbus.ObjectPath, *dbus.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d, ok := f.devices[dev]
+	if !ok || conn != "/" {
+		return "", dbus.NewError("org.freedesktop.NetworkManager.UnknownConnection", []interface{}{"no such connection"})
+	}
+	d.state = NetDeviceActivated
+	f.connectivity = ConnectivityFull
+	return nmPath + "/ActiveConnection/2", nil
+}
+
+func TestNetworkManager(t *testing.T) {
+	conn, server := testConnPair(t)
+	fake := startFakeNetworkManager(t, server)
+	nm := NewNetworkManager(conn)
+
+	if c, err := nm.Connectivity(); err != nil || c != ConnectivityNone {
+		t.Errorf("Connectivity() = %v, %v, want %v", c, err, ConnectivityNone)
+	}
+
+	devices, err := nm.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDevices := []NetDevice{
+		{Path: nmPath + "/Devices/2", Interface: "enp3s0", Type: NetDeviceEthernet, State: NetDeviceDisconnected},
+		{Path: nmPath + "/Devices/3", Interface: "wlp2s0", Type: NetDeviceWifi, State: NetDeviceDisconnected},
+	}
+	if !reflect.DeepEqual(devices, wantDevices) {
+		t.Fatalf("Devices() = %+v, want %+v", devices, wantDevices)
+	}
+	wired, wifi := devices[0], devices[1]
+
+	if err := nm.RequestScan(wifi); err != nil {
+		t.Errorf("RequestScan() failed: %v", err)
+	}
+	if fake.scans != 1 {
+		t.Errorf("got %d scans, want 1", fake.scans)
+	}
+	aps, err := nm.AccessPoints(wifi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAPs := []AccessPoint{
+		{Path: nmPath + "/AccessPoint/2", SSID: "home", Strength: 82, Secured: true},
+		{Path: nmPath + "/AccessPoint/3", SSID: "cafe", Strength: 55},
+	}
+	if !reflect.DeepEqual(aps, wantAPs) {
+		t.Fatalf("AccessPoints() = %+v, want %+v", aps, wantAPs)
+	}
+
+	if err := nm.ConnectWifi(wifi, aps[0], ""); err == nil {
+		t.Error("ConnectWifi() to a secured network without a passphrase succeeded")
+	}
+	if err := nm.ConnectWifi(wifi, aps[0], "wrong"); err == nil || !strings.Contains(err.Error(), "bad passphrase") {
+		t.Errorf("ConnectWifi() with a bad passphrase returned %v", err)
+	}
+	if err := nm.ConnectWifi(wifi, aps[0], "hunter22"); err != nil {
+		t.Fatalf("ConnectWifi() failed: %v", err)
+	}
+	if err := nm.ConnectWired(wired); err != nil {
+		t.Fatalf("ConnectWired() failed: %v", err)
+	}
+
+	if c, err := nm.Connectivity(); err != nil || c != ConnectivityFull {
+		t.Errorf("Connectivity() = %v, %v, want %v", c, err, ConnectivityFull)
+	}
+	if devices, err = nm.Devices(); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range devices {
+		if d.State != NetDeviceActivated {
+			t.Errorf("%s is %v, want %v", d.Interface, d.State, NetDeviceActivated)
+		}
+	}
+	if devices[1].ActiveSSID != "home" {
+		t.Errorf("%s ActiveSSID = %q, want %q", devices[1].Interface, devices[1].ActiveSSID, "home")
+	}
+
+	saved, err := nm.SavedConnections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSaved := []SavedConnection{
+		{Path: nmSettingsPath + "/1", ID: "Wired connection 1", UUID: "0b7a5f3e-1111-4a4a-9e9e-000000000001", Type: "802-3-ethernet", Filename: "/run/NetworkManager/system-connections/Wired connection 1.nmconnection"},
+		{Path: nmSettingsPath + "/2", ID: "home", UUID: "0b7a5f3e-1111-4a4a-9e9e-000000000002", Type: "802-11-wireless", Filename: "/etc/NetworkManager/system-connections/home.nmconnection"},
+	}
+	if !reflect.DeepEqual(saved, wantSaved) {
+		t.Fatalf("SavedConnections() = %+v, want %+v", saved, wantSaved)
+	}
+	if saved[0].Persistent() || !saved[1].Persistent() {
+		t.Errorf("Persistent() = %v, %v, want false, true", saved[0].Persistent(), saved[1].Persistent())
+	}
+}
@@ -0,0 +1,109 @@
+package z
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// lsblkBool decodes lsblk boolean columns, which older versions of lsblk
+// output as "0" or "1".
+type lsblkBool bool
+
+func (b *lsblkBool) UnmarshalJSON(d []byte) error {
+	switch strings.Trim(string(d), `"`) {
+	case "1", "true":
+		*b = true
+	case "0", "false", "null", "":
+		*b = false
+	default:
+		return fmt.Errorf("invalid lsblk boolean %s", string(d))
+	}
+	return nil
+}
+
+// RemovableFs describes a filesystem on removable media, such as a USB drive.
+type RemovableFs struct {
+	Path       string
+	FsType     string
+	Mountpoint string
+}
+
+// parseRemovableFs returns the filesystems on removable devices, given the
+// output of lsblk -J -l -p -o NAME,RM,HOTPLUG,FSTYPE,MOUNTPOINT.
+func parseRemovableFs(lsblkJSON []byte) ([]RemovableFs, error) {
+	var out struct {
+		Devices []struct {
+			Name       string    `json:"name"`
+			RM         lsblkBool `json:"rm"`
+			Hotplug    lsblkBool `json:"hotplug"`
+			FsType     string    `json:"fstype"`
+			Mountpoint string    `json:"mountpoint"`
+		} `json:"blockdevices"`
+	}
+	if err := json.Unmarshal(lsblkJSON, &out); err != nil {
+		return nil, fmt.Errorf("decoding lsblk output: %v", err)
+	}
+
+	var fs []RemovableFs
+	for _, d := range out.Devices {
+		if (!d.RM && !d.Hotplug) || d.FsType == "" || d.FsType == "crypto_LUKS" || d.FsType == "swap" {
+			continue
+		}
+		fs = append(fs, RemovableFs{Path: d.Name, FsType: d.FsType, Mountpoint: d.Mountpoint})
+	}
+	return fs, nil
+}
+
+// ListRemovableFs returns the filesystems on removable devices.
+func ListRemovableFs() ([]RemovableFs, error) {
+	out, err := exec.Command("lsblk", "-J", "-l", "-p", "-o", "NAME,RM,HOTPLUG,FSTYPE,MOUNTPOINT").Output()
+	if err != nil {
+		return nil, fmt.Errorf("lsblk: %v", err)
+	}
+	return parseRemovableFs(out)
+}
+
+// ReadFromRemovable returns the contents of the file at the given path,
+// relative to the root of the first removable filesystem which has it.
+// Filesystems which are not mounted are temporarily mounted read-only.
+func ReadFromRemovable(relPath string) ([]byte, error) {
+	relPath = filepath.Clean("/" + relPath)
+	fss, err := ListRemovableFs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fs := range fss {
+		if fs.Mountpoint != "" {
+			if d, err := ioutil.ReadFile(filepath.Join(fs.Mountpoint, relPath)); err == nil {
+				return d, nil
+			}
+			continue
+		}
+
+		d, err := readFromUnmounted(fs.Path, relPath)
+		if err == nil {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("%s not found on any removable drive", relPath)
+}
+
+func readFromUnmounted(dev, relPath string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "twlinst-removable")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(dir)
+
+	if out, err := exec.Command("sudo", "mount", "-o", "ro", dev, dir).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("mount %s: %s (%v)", dev, strings.TrimSpace(string(out)), err)
+	}
+	defer exec.Command("sudo", "umount", dir).Run()
+	return ioutil.ReadFile(filepath.Join(dir, relPath))
+}
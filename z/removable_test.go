@@ -0,0 +1,46 @@
+package z
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRemovableFs(t *testing.T) {
+	tcs := []struct {
+		name string
+		in   string
+		want []RemovableFs
+	}{
+		{
+			"boolean columns",
+			`{"blockdevices": [
+				{"name": "/dev/sda", "rm": false, "hotplug": false, "fstype": null, "mountpoint": null},
+				{"name": "/dev/sda1", "rm": false, "hotplug": false, "fstype": "ext4", "mountpoint": "/"},
+				{"name": "/dev/sdb", "rm": true, "hotplug": true, "fstype": null, "mountpoint": null},
+				{"name": "/dev/sdb1", "rm": true, "hotplug": true, "fstype": "vfat", "mountpoint": "/run/media/usb"},
+				{"name": "/dev/sdb2", "rm": true, "hotplug": true, "fstype": "crypto_LUKS", "mountpoint": null}
+			]}`,
+			[]RemovableFs{{Path: "/dev/sdb1", FsType: "vfat", Mountpoint: "/run/media/usb"}},
+		},
+		{
+			"string columns",
+			`{"blockdevices": [
+				{"name": "/dev/nvme0n1p1", "rm": "0", "hotplug": "0", "fstype": "vfat", "mountpoint": "/boot"},
+				{"name": "/dev/sdc1", "rm": "0", "hotplug": "1", "fstype": "exfat", "mountpoint": null}
+			]}`,
+			[]RemovableFs{{Path: "/dev/sdc1", FsType: "exfat"}},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseRemovableFs([]byte(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseRemovableFs() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package z
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// zoneinfoDirs are searched in order for the timezone database.
+var zoneinfoDirs = []string{
+	"/etc/zoneinfo",
+	"/usr/share/zoneinfo",
+	"/run/current-system/sw/share/zoneinfo",
+}
+
+// Timezone describes a timezone in the tz database.
+type Timezone struct {
+	// Name is the name of the zone, such as Europe/London.
+	Name string
+	// Countries are the names of the countries using the zone, if known.
+	Countries []string
+	// Comment distinguishes zones within a country, such as "Mountain (most areas)".
+	Comment string
+}
+
+// Region returns the first component of the name, such as Europe.
+func (tz *Timezone) Region() string {
+	if idx := strings.Index(tz.Name, "/"); idx > 0 {
+		return tz.Name[:idx]
+	}
+	return tz.Name
+}
+
+// City returns the rest of the name in readable form, such as
+// "Argentina / Buenos Aires".
+func (tz *Timezone) City() string {
+	idx := strings.Index(tz.Name, "/")
+	if idx < 0 {
+		return tz.Name
+	}
+	return strings.Replace(strings.Replace(tz.Name[idx+1:], "_", " ", -1), "/", " / ", -1)
+}
+
+func scanTab(r io.Reader, minFields int, fn func(fields []string)) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if strings.HasPrefix(s.Text(), "#") {
+			continue
+		}
+		if fields := strings.Split(s.Text(), "\t"); len(fields) >= minFields {
+			fn(fields)
+		}
+	}
+	return s.Err()
+}
+
+// parseZoneTab returns the zones described in zone1970.tab, given the
+// country names from iso3166.tab. Zones are sorted by name.
+func parseZoneTab(zoneTab, iso3166Tab io.Reader) ([]Timezone, error) {
+	countries := map[string]string{}
+	if err := scanTab(iso3166Tab, 2, func(f []string) { countries[f[0]] = f[1] }); err != nil {
+		return nil, err
+	}
+
+	var out []Timezone
+	err := scanTab(zoneTab, 3, func(f []string) {
+		tz := Timezone{Name: f[2]}
+		for _, code := range strings.Split(f[0], ",") {
+			if name, ok := countries[code]; ok {
+				tz.Countries = append(tz.Countries, name)
+			} else {
+				tz.Countries = append(tz.Countries, code)
+			}
+		}
+		if len(f) > 3 {
+			tz.Comment = f[3]
+		}
+		out = append(out, tz)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// isTZif returns true if the file is in the compiled zoneinfo format.
+func isTZif(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(f, magic)
+	return err == nil && string(magic) == "TZif"
+}
+
+// scanZoneinfo returns the zones in a zoneinfo directory, for systems
+// which lack zone1970.tab. The posix and right variant trees are skipped.
+func scanZoneinfo(dir string) ([]Timezone, error) {
+	var out []Timezone
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if info.IsDir() {
+			if rel == "posix" || rel == "right" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if rel == "localtime" || rel == "posixrules" || rel == "Factory" || !isTZif(path) {
+			return nil
+		}
+		out = append(out, Timezone{Name: filepath.ToSlash(rel)})
+		return nil
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, err
+}
+
+func zoneinfoDir() (string, error) {
+	dirs := zoneinfoDirs
+	if d := os.Getenv("TZDIR"); d != "" {
+		dirs = append([]string{d}, dirs...)
+	}
+	for _, d := range dirs {
+		if info, err := os.Stat(d); err == nil && info.IsDir() {
+			return d, nil
+		}
+	}
+	return "", fmt.Errorf("no timezone database found, tried %s", strings.Join(dirs, ", "))
+}
+
+// ReadTimezones returns the timezones known to the system. Zones are read
+// from zone1970.tab where available, which omits legacy aliases, and UTC
+// is always included.
+func ReadTimezones() ([]Timezone, error) {
+	dir, err := zoneinfoDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Timezone
+	zoneTab, zoneErr := os.Open(filepath.Join(dir, "zone1970.tab"))
+	isoTab, isoErr := os.Open(filepath.Join(dir, "iso3166.tab"))
+	if zoneErr == nil && isoErr == nil {
+		out, err = parseZoneTab(zoneTab, isoTab)
+	} else {
+		out, err = scanZoneinfo(dir)
+	}
+	if zoneErr == nil {
+		zoneTab.Close()
+	}
+	if isoErr == nil {
+		isoTab.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if FindTimezone(out, "UTC") == nil {
+		out = append(out, Timezone{Name: "UTC"})
+	}
+	return out, nil
+}
+
+// FindTimezone returns the zone with the given name, or nil.
+func FindTimezone(zones []Timezone, name string) *Timezone {
+	for i := range zones {
+		if zones[i].Name == name {
+			return &zones[i]
+		}
+	}
+	return nil
+}
+
+// IsTimezone returns true if the name is a zone in the tz database,
+// including legacy aliases such as Europe/Amsterdam which ReadTimezones
+// omits.
+func IsTimezone(name string) (bool, error) {
+	dir, err := zoneinfoDir()
+	if err != nil {
+		return false, err
+	}
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "..") {
+		return false, nil
+	}
+	return isTZif(filepath.Join(dir, filepath.FromSlash(name))), nil
+}
+
+// CurrentTimezone returns the name of the zone the system is configured
+// to use, or the empty string if it is unknown.
+func CurrentTimezone() string {
+	if target, err := os.Readlink("/etc/localtime"); err == nil {
+		if idx := strings.Index(target, "zoneinfo/"); idx >= 0 {
+			name := target[idx+len("zoneinfo/"):]
+			if name == "Etc/UTC" || name == "Etc/UCT" {
+				return "UTC"
+			}
+			return name
+		}
+	}
+	if d, err := ioutil.ReadFile("/etc/timezone"); err == nil {
+		return strings.TrimSpace(string(d))
+	}
+	return ""
+}
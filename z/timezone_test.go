@@ -0,0 +1,69 @@
+package z
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseZoneTab(t *testing.T) {
+	const (
+		zoneTab = "# tzdb timezone descriptions\n" +
+			"#codes\tcoordinates\tTZ\tcomments\n" +
+			"GB,GG,IM,JE\t+513030-0000731\tEurope/London\n" +
+			"AR\t-3436-05827\tAmerica/Argentina/Buenos_Aires\tBuenos Aires (BA, CF)\n" +
+			"XX\t+0000+00000\tEtc/Nowhere\n"
+		isoTab = "# ISO 3166 alpha-2 country codes\n" +
+			"AR\tArgentina\n" +
+			"GB\tBritain (UK)\n" +
+			"GG\tGuernsey\n" +
+			"IM\tIsle of Man\n" +
+			"JE\tJersey\n"
+	)
+
+	got, err := parseZoneTab(strings.NewReader(zoneTab), strings.NewReader(isoTab))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Timezone{
+		{Name: "America/Argentina/Buenos_Aires", Countries: []string{"Argentina"}, Comment: "Buenos Aires (BA, CF)"},
+		{Name: "Etc/Nowhere", Countries: []string{"XX"}},
+		{Name: "Europe/London", Countries: []string{"Britain (UK)", "Guernsey", "Isle of Man", "Jersey"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseZoneTab() = %+v, want %+v", got, want)
+	}
+
+	if r, c := got[0].Region(), got[0].City(); r != "America" || c != "Argentina / Buenos Aires" {
+		t.Errorf("Region(), City() = %q, %q", r, c)
+	}
+}
+
+func TestReadTimezones(t *testing.T) {
+	zones, err := ReadTimezones()
+	if err != nil {
+		t.Skipf("no timezone database: %v", err)
+	}
+	for _, name := range []string{"UTC", "Europe/London"} {
+		if FindTimezone(zones, name) == nil {
+			t.Errorf("ReadTimezones() does not include %s", name)
+		}
+	}
+}
+
+func TestIsTimezone(t *testing.T) {
+	if _, err := zoneinfoDir(); err != nil {
+		t.Skipf("no timezone database: %v", err)
+	}
+	for name, want := range map[string]bool{
+		"Europe/London":   true,
+		"UTC":             true,
+		"Mars/Olympus":    false,
+		"../zoneinfo/UTC": false,
+		"":                false,
+	} {
+		if got, err := IsTimezone(name); err != nil || got != want {
+			t.Errorf("IsTimezone(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+}
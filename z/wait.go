@@ -0,0 +1,73 @@
+package z
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// pollInterval is how often device readiness is checked.
+const pollInterval = 100 * time.Millisecond
+
+// settleUdev waits for udev to finish processing queued events, for at most
+// the given duration.
+func settleUdev(timeout time.Duration) error {
+	secs := int(math.Ceil(timeout.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	if out, err := exec.Command("udevadm", "settle", "--timeout", strconv.Itoa(secs)).CombinedOutput(); err != nil {
+		return fmt.Errorf("udevadm settle: %s (%v)", string(out), err)
+	}
+	return nil
+}
+
+// WaitForDevice blocks until the device node at path exists and udev has
+// processed the events for it, or the timeout expires.
+func WaitForDevice(path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := os.Stat(path)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %v waiting for %s to appear", timeout, path)
+		}
+		time.Sleep(pollInterval)
+	}
+
+	return settleUdev(time.Until(deadline))
+}
+
+// WaitForFsUUID waits like WaitForDevice, and then until udev has published
+// the UUID of the filesystem on the device, which is returned.
+func WaitForFsUUID(path string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	if err := WaitForDevice(path, timeout); err != nil {
+		return "", err
+	}
+
+	for {
+		info, err := GetUdevDiskInfo(path, false)
+		if err != nil {
+			return "", err
+		}
+		if info.FsUUID != "" {
+			return info.FsUUID, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("timed out after %v waiting for filesystem UUID of %s", timeout, path)
+		}
+		time.Sleep(pollInterval)
+		if err := settleUdev(time.Until(deadline)); err != nil {
+			return "", err
+		}
+	}
+}
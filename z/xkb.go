@@ -0,0 +1,133 @@
+package z
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// xkbRulesPaths are searched in order for the list of XKB layouts.
+var xkbRulesPaths = []string{
+	"/run/current-system/sw/share/X11/xkb/rules/base.lst",
+	"/usr/share/X11/xkb/rules/base.lst",
+	"/etc/X11/xkb/rules/base.lst",
+}
+
+// XkbVariant is a variant of a keyboard layout.
+type XkbVariant struct {
+	Name, Description string
+}
+
+// XkbLayout is a keyboard layout, as known to XKB.
+type XkbLayout struct {
+	Name, Description string
+	Variants          []XkbVariant
+}
+
+// HasVariant returns true if the layout has a variant of the given name.
+func (l *XkbLayout) HasVariant(name string) bool {
+	for _, v := range l.Variants {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseXkbList parses the layouts and variants from an XKB rules list, such
+// as base.lst. Layouts are sorted by description.
+func parseXkbList(r io.Reader) ([]XkbLayout, error) {
+	var (
+		section  string
+		layouts  []XkbLayout
+		byName   = map[string]int{}
+		variants = map[string][]XkbVariant{}
+	)
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "!") {
+			section = strings.TrimSpace(strings.TrimPrefix(line, "!"))
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name, desc := fields[0], strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+		switch section {
+		case "layout":
+			byName[name] = len(layouts)
+			layouts = append(layouts, XkbLayout{Name: name, Description: desc})
+		case "variant":
+			// Variants are described as "<layout>: <description>".
+			idx := strings.Index(desc, ":")
+			if idx < 0 {
+				continue
+			}
+			layout := desc[:idx]
+			variants[layout] = append(variants[layout], XkbVariant{Name: name, Description: strings.TrimSpace(desc[idx+1:])})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	for layout, v := range variants {
+		if idx, ok := byName[layout]; ok {
+			sort.Slice(v, func(i, j int) bool { return v[i].Description < v[j].Description })
+			layouts[idx].Variants = v
+		}
+	}
+	sort.Slice(layouts, func(i, j int) bool { return layouts[i].Description < layouts[j].Description })
+	return layouts, nil
+}
+
+// ReadXkbLayouts returns the keyboard layouts available on the system.
+func ReadXkbLayouts() ([]XkbLayout, error) {
+	paths := xkbRulesPaths
+	if root := os.Getenv("XKB_CONFIG_ROOT"); root != "" {
+		paths = append([]string{filepath.Join(root, "rules", "base.lst")}, paths...)
+	}
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		return parseXkbList(f)
+	}
+	return nil, fmt.Errorf("no XKB rules found, tried %s", strings.Join(paths, ", "))
+}
+
+// FindXkbLayout returns the layout with the given name, or nil.
+func FindXkbLayout(layouts []XkbLayout, name string) *XkbLayout {
+	for i := range layouts {
+		if layouts[i].Name == name {
+			return &layouts[i]
+		}
+	}
+	return nil
+}
+
+// SetXkbLayout applies the keyboard layout to the running X session.
+func SetXkbLayout(layout, variant string) error {
+	args := []string{"-layout", layout}
+	if variant != "" {
+		args = append(args, "-variant", variant)
+	}
+	if out, err := exec.Command("setxkbmap", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("setxkbmap: %s (%v)", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
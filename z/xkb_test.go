@@ -0,0 +1,51 @@
+package z
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testXkbList = `! model
+  pc105           Generic 105-key PC
+  pc86            Generic 86-key PC
+
+! layout
+  us              English (US)
+  de              German
+  gb              English (UK)
+
+! variant
+  intl            us: English (US, intl., with dead keys)
+  dvorak          us: English (Dvorak)
+  nodeadkeys      de: German (no dead keys)
+  bogus           xx: Not a layout
+
+! option
+  grp                  Switching to another layout
+  grp:switch           Right Alt (while pressed)
+`
+
+func TestParseXkbList(t *testing.T) {
+	got, err := parseXkbList(strings.NewReader(testXkbList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []XkbLayout{
+		{Name: "gb", Description: "English (UK)"},
+		{Name: "us", Description: "English (US)", Variants: []XkbVariant{
+			{Name: "dvorak", Description: "English (Dvorak)"},
+			{Name: "intl", Description: "English (US, intl., with dead keys)"},
+		}},
+		{Name: "de", Description: "German", Variants: []XkbVariant{
+			{Name: "nodeadkeys", Description: "German (no dead keys)"},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseXkbList() = %+v, want %+v", got, want)
+	}
+
+	if l := FindXkbLayout(got, "de"); l == nil || !l.HasVariant("nodeadkeys") || l.HasVariant("intl") {
+		t.Errorf("FindXkbLayout(de) = %+v", l)
+	}
+}